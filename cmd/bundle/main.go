@@ -0,0 +1,394 @@
+// Command bundle packs task bundles and moves them in and out of a Grader
+// server, so a course can be kept in git:
+//
+//	bundle pack -o task.zip dir
+//	bundle unpack -o dir task.zip
+//	bundle export -task 12 -o dir
+//	bundle export -course 3 -o course/
+//	bundle import -course 3 course/
+//	bundle sync -task 12 dir
+//
+// Server commands log in as -user with the password in GRADER_PASSWORD.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"grader/pkg/bundle"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const usage = `usage: bundle <command> [flags] [path]
+
+commands:
+  pack    zip a bundle directory
+  unpack  unzip a bundle into a directory
+  export  download a task or a whole course from the server
+  import  create tasks on the server from bundles, drafts in the course
+  sync    update a task on the server from a bundle
+`
+
+func main() {
+	log.SetFlags(0)
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "pack":
+		err = pack(args)
+	case "unpack":
+		err = unpack(args)
+	case "export":
+		err = export(args)
+	case "import":
+		err = importBundles(args)
+	case "sync":
+		err = sync(args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func pack(args []string) error {
+	fs := flag.NewFlagSet("pack", flag.ExitOnError)
+	out := fs.String("o", "task.zip", "archive to write")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("pack: want a bundle directory")
+	}
+
+	b, err := bundle.ReadDir(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	data, err := b.Bytes()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(*out, data, 0640)
+}
+
+func unpack(args []string) error {
+	fs := flag.NewFlagSet("unpack", flag.ExitOnError)
+	out := fs.String("o", ".", "directory to write")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("unpack: want a bundle archive")
+	}
+
+	b, err := readZip(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	return b.WriteDir(*out)
+}
+
+func export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	c := remoteFlags(fs)
+	taskID := fs.Int("task", 0, "task to export")
+	courseID := fs.Int("course", -1, "course to export, 0 for the tasks outside courses")
+	out := fs.String("o", ".", "directory to write, or a .zip for a single task")
+	fs.Parse(args)
+
+	if (*taskID == 0) == (*courseID < 0) {
+		return errors.New("export: want either -task or -course")
+	}
+
+	err := c.login()
+	if err != nil {
+		return err
+	}
+
+	if *taskID != 0 {
+		data, err := c.get("/api/v1/task/bundle?id=" + strconv.Itoa(*taskID))
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(*out, ".zip") {
+			return os.WriteFile(*out, data, 0640)
+		}
+
+		b, err := bundle.Read(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return err
+		}
+
+		return b.WriteDir(*out)
+	}
+
+	data, err := c.get("/api/v1/course/bundle?course_id=" + strconv.Itoa(*courseID))
+	if err != nil {
+		return err
+	}
+
+	bundles, err := bundle.ReadCourse(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+
+	for dir, b := range bundles {
+		err = b.WriteDir(filepath.Join(*out, dir))
+		if err != nil {
+			return err
+		}
+		log.Println("exported", dir)
+	}
+
+	return nil
+}
+
+func importBundles(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	c := remoteFlags(fs)
+	courseID := fs.Int("course", 0, "course to import into, 0 for none")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return errors.New("import: want bundle directories or archives")
+	}
+
+	bundles := map[string]*bundle.Bundle{}
+	for _, p := range fs.Args() {
+		found, err := readAny(p)
+		if err != nil {
+			return err
+		}
+		for name, b := range found {
+			bundles[name] = b
+		}
+	}
+
+	err := c.login()
+	if err != nil {
+		return err
+	}
+
+	for name, b := range bundles {
+		var res struct {
+			ID int `json:"id"`
+		}
+
+		err = c.upload("/api/v1/task/import", url.Values{"course_id": {strconv.Itoa(*courseID)}}, b, &res)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		log.Printf("imported %s as task %d", name, res.ID)
+	}
+
+	return nil
+}
+
+func sync(args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	c := remoteFlags(fs)
+	taskID := fs.Int("task", 0, "task to update")
+	fs.Parse(args)
+
+	if *taskID == 0 || fs.NArg() != 1 {
+		return errors.New("sync: want -task and a bundle")
+	}
+
+	found, err := readAny(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(found) != 1 {
+		return errors.New("sync: want a single task bundle")
+	}
+
+	err = c.login()
+	if err != nil {
+		return err
+	}
+
+	for _, b := range found {
+		err = c.upload("/api/v1/task/bundle", url.Values{"id": {strconv.Itoa(*taskID)}}, b, nil)
+	}
+
+	return err
+}
+
+// readAny reads a bundle or a course of them from a directory or a zip,
+// by name.
+func readAny(p string) (map[string]*bundle.Bundle, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := bundle.Read(bytes.NewReader(data), int64(len(data)))
+		if err == nil {
+			return map[string]*bundle.Bundle{p: b}, nil
+		}
+
+		return bundle.ReadCourse(bytes.NewReader(data), int64(len(data)))
+	}
+
+	if _, err = os.Stat(filepath.Join(p, bundle.ManifestName)); err == nil {
+		b, err := bundle.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]*bundle.Bundle{p: b}, nil
+	}
+
+	return bundle.ReadCourseDir(p)
+}
+
+func readZip(p string) (*bundle.Bundle, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return bundle.Read(bytes.NewReader(data), int64(len(data)))
+}
+
+type client struct {
+	server string
+	user   string
+	token  string
+	http   *http.Client
+}
+
+func remoteFlags(fs *flag.FlagSet) *client {
+	c := &client{
+		http: &http.Client{
+			Timeout: 2 * time.Minute,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+	fs.StringVar(&c.server, "server", "http://localhost:3000", "server addr")
+	fs.StringVar(&c.user, "user", os.Getenv("GRADER_USER"), "user to log in as, GRADER_USER by default")
+
+	return c
+}
+
+func (c *client) login() error {
+	if c.user == "" {
+		return errors.New("no -user to log in as")
+	}
+
+	res, err := c.http.PostForm(c.server+"/api/v1/user/login", url.Values{
+		"username": {c.user},
+		"password": {os.Getenv("GRADER_PASSWORD")},
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == "token" {
+			c.token = cookie.Value
+			return nil
+		}
+	}
+
+	return fmt.Errorf("login as %s failed: %s", c.user, res.Status)
+}
+
+func (c *client) get(path string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, c.server+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(req)
+}
+
+// upload posts the bundle with the form values, decoding the JSON answer
+// into res unless it's nil.
+func (c *client) upload(path string, values url.Values, b *bundle.Bundle, res interface{}) error {
+	data, err := b.Bytes()
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, vs := range values {
+		for _, v := range vs {
+			mw.WriteField(k, v)
+		}
+	}
+	fw, err := mw.CreateFormFile("bundle", "task.zip")
+	if err != nil {
+		return err
+	}
+	fw.Write(data)
+	err = mw.Close()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.server+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+
+	out, err := c.do(req)
+	if err != nil || res == nil {
+		return err
+	}
+
+	return json.Unmarshal(out, res)
+}
+
+func (c *client) do(req *http.Request) ([]byte, error) {
+	req.Header.Set("Cookie", "token="+c.token)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, res.Status, strings.TrimSpace(string(data)))
+	}
+
+	return data, nil
+}
@@ -1,26 +1,37 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"grader/pkg/grader"
 	graderDelivery "grader/pkg/grader/delivery"
-	graderRepository "grader/pkg/grader/repo"
 	graderService "grader/pkg/grader/service"
+	"grader/pkg/graderauth"
+	"grader/pkg/tracing"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	serverAddr  = flag.String("server", "http://localhost:3000", "server addr")
+	gracePeriod = flag.Duration("grace", 2*time.Minute, "time to finish running gradings on shutdown")
 )
 
 type config struct {
-	Login    string
-	Password string
+	// KeyID and Secret must match one of the server GRADER_KEYS.
+	KeyID  string
+	Secret string
 }
 
 func main() {
@@ -33,17 +44,21 @@ func main() {
 	}
 
 	cfg := config{}
-	cfg.Login = os.Getenv("GRADER_LOGIN")
-	cfg.Password = os.Getenv("GRADER_PASSWORD")
-
-	formData := url.Values{
-		"username": {cfg.Login},
-		"password": {cfg.Password},
+	cfg.KeyID = os.Getenv("GRADER_KEY_ID")
+	cfg.Secret = os.Getenv("GRADER_SECRET")
+	if cfg.KeyID == "" || cfg.Secret == "" {
+		log.Fatalln("GRADER_KEY_ID and GRADER_SECRET must be set")
 	}
 
 	logger, _ := zap.NewProduction()
 	defer logger.Sync()
 
+	shutdownTracing, err := tracing.Init(context.Background(), "grader", logger)
+	if err != nil {
+		log.Fatalln("cant init tracing:", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	config := &grader.Config{}
 	data, err := ioutil.ReadFile("../../configs/config.json")
 	err = json.Unmarshal(data, config)
@@ -54,21 +69,57 @@ func main() {
 	port := ":8080"
 	r := chi.NewRouter()
 
-	graderRepo := graderRepository.NewGraderRepo()
-	graderService := graderService.NewGraderService(config, graderRepo)
+	graderService := graderService.NewGraderService(config)
+	hostname, _ := os.Hostname()
+
 	graderHandler := &graderDelivery.GraderHandler{
 		GraderService: graderService,
 		Logger:        logger,
-		FormData:      formData,
+		Signer: &graderauth.Signer{
+			KeyID:  cfg.KeyID,
+			Secret: []byte(cfg.Secret),
+		},
+		ServerAddr: *serverAddr,
+		Host:       hostname,
 	}
 
 	r.Use(middleware.RequestID)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Logger)
 
-	graderHandler.GraderLogin()
 	r.Post("/api/v1/grader/grade", graderHandler.GradeSolution)
+	r.Get("/api/v1/grader/capabilities", graderHandler.Capabilities)
+	r.Get("/api/v1/grader/load", graderHandler.Load)
+	r.Handle("/metrics", promhttp.Handler())
+
+	srv := &http.Server{
+		Addr:    port,
+		Handler: r,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		log.Printf("Grader start on port %s", port)
+
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
 
-	log.Printf("Grader start on port %s", port)
-	log.Fatal(http.ListenAndServe(port, r))
+	<-ctx.Done()
+	log.Println("shutting down grader, waiting for running gradings")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *gracePeriod)
+	defer cancel()
+
+	err = srv.Shutdown(shutdownCtx)
+	if err != nil {
+		// Closing connections cancels request contexts and kills the
+		// remaining containers, the dispatcher requeues their jobs.
+		log.Printf("grace period expired: %s", err)
+		srv.Close()
+	}
 }
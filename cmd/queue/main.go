@@ -1,83 +1,241 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/streadway/amqp"
 	"go.uber.org/zap"
 	"grader/pkg/queue"
 	queueDelivery "grader/pkg/queue/delivery"
+	queueRepository "grader/pkg/queue/repo"
+	queueService "grader/pkg/queue/service"
+	"grader/pkg/tracing"
 	"grader/pkg/utils"
 	"log"
 	"net/http"
-	"runtime"
+	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
 var (
-	rabbitConn *amqp.Connection
-	rabbitChan *amqp.Channel
+	graderAddrs  = flag.String("graders", "http://localhost:8080", "comma separated grader addrs")
+	gracePeriod  = flag.Duration("grace", 30*time.Second, "time to finish running gradings on shutdown")
+	prefetch     = flag.Int("prefetch", 20, "deliveries buffered per queue for fair scheduling")
+	metricsAddr  = flag.String("metrics", ":9100", "addr to serve /metrics on")
+	pollInterval = flag.Duration("poll", 5*time.Second, "how often to ask graders for their load")
 )
 
 func main() {
 	flag.Parse()
 	var err error
 
-	httpClient := &http.Client{
-		Timeout: time.Duration(2 * time.Minute),
-	}
+	// Requests carry their own deadlines, a grading one follows the time
+	// limit of its task.
+	httpClient := &http.Client{}
 	logger, _ := zap.NewProduction()
 	defer logger.Sync()
 
-	rabbitConn, err = amqp.Dial(*queue.RabbitAddr)
-	utils.FatalOnError("cant connect to rabbit", err)
-
-	rabbitChan, err = rabbitConn.Channel()
-	utils.FatalOnError("cant open chan", err)
-	defer rabbitChan.Close()
-
-	_, err = rabbitChan.QueueDeclare(
-		queue.SolutionQueueName, // name
-		true,                    // durable
-		false,                   // delete when unused
-		false,                   // exclusive
-		false,                   // no-wait
-		nil,                     // arguments
-	)
-	utils.FatalOnError("cant init queue", err)
-
-	err = rabbitChan.Qos(
-		1,     // prefetch count
-		0,     // prefetch size
-		false, // global
-	)
-	utils.FatalOnError("cant set QoS", err)
-
-	solutions, err := rabbitChan.Consume(
-		queue.SolutionQueueName, // queue
-		"",                      // consumer
-		false,                   // auto-ack
-		false,                   // exclusive
-		false,                   // no-local
-		false,                   // no-wait
-		nil,                     // args
-	)
-	utils.FatalOnError("cant register consumer", err)
+	hostname, _ := os.Hostname()
+
+	shutdownTracing, err := tracing.Init(context.Background(), "queue", logger)
+	utils.FatalOnError("cant init tracing", err)
+	defer shutdownTracing(context.Background())
+
+	var addrs []string
+	for _, addr := range strings.Split(*graderAddrs, ",") {
+		addrs = append(addrs, strings.TrimSpace(addr))
+	}
 
 	queueHandler := &queueDelivery.QueueHandler{
-		Client: httpClient,
-		Logger: logger,
+		Client:      httpClient,
+		Logger:      logger,
+		Host:        hostname,
+		Graders:     queueRepository.NewGraderRepo(),
+		GraderAddrs: addrs,
+		Cancelled:   queueService.NewCancelSet(time.Hour),
 	}
 
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
+	broker := queue.NewConnection(*queue.RabbitAddr, logger)
+	queueHandler.Broker = broker
+	broker.OnConnect(queue.DeclareSolutionExchange)
+	broker.OnConnect(queue.DeclareResultQueue)
+
+	cancelQueue := fmt.Sprintf("solution_cancel.%s.%d", hostname, os.Getpid())
+	broker.OnConnect(func(ch *amqp.Channel) error {
+		return queue.DeclareCancelQueue(ch, cancelQueue)
+	})
+	broker.OnConnect(func(ch *amqp.Channel) error {
+		err := ch.Qos(
+			*prefetch, // prefetch count
+			0,         // prefetch size
+			false,     // global
+		)
+		if err != nil {
+			return err
+		}
+
+		for routingKey := range queueHandler.Graders.Routes() {
+			_, err = queue.DeclareRoute(ch, routingKey)
+			if err != nil {
+				return err
+			}
+		}
 
-	numWorkers := runtime.NumCPU()
+		return nil
+	})
 
-	for i := 0; i < numWorkers; i++ {
-		go queueHandler.SolutionWorker(solutions)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	scheduler := queueService.NewFairScheduler()
+	consumers := &routeConsumers{
+		broker:    broker,
+		scheduler: scheduler,
+		tags:      make(map[string]bool),
 	}
 
+	// Graders registering later may bring routes nobody consumes yet.
+	queueHandler.Graders.OnRoute(func(routingKey string) {
+		err := consumers.start(routingKey)
+		if err != nil {
+			logger.Error("cant consume route", zap.String("routing_key", routingKey), zap.Error(err))
+		}
+	})
+	queueHandler.DiscoverGraders()
+
+	cancels, err := broker.Consume(cancelQueue, cancelQueue)
+	utils.FatalOnError("cant consume cancellations", err)
+	go queueHandler.ConsumeCancels(cancels)
+
+	go broker.Run()
+	defer broker.Close()
+
+	// gradingCtx outlives ctx by the grace period so running gradings can finish.
+	gradingCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+
+	pool := queueService.NewWorkerPool(func() bool {
+		return queueHandler.HandleNext(gradingCtx, scheduler)
+	})
+	go queueHandler.PollLoad(ctx, *pollInterval, pool)
+
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", promhttp.Handler())
+	metricsSrv := &http.Server{
+		Addr:    *metricsAddr,
+		Handler: metricsMux,
+	}
+	defer metricsSrv.Close()
+
+	go func() {
+		err := metricsSrv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error("metrics server stopped", zap.Error(err))
+		}
+	}()
+
 	log.Println("queue processor started")
-	wg.Wait()
+	<-ctx.Done()
+	log.Println("shutting down, stop consuming")
+
+	consumers.stop(logger)
+	scheduler.Close()
+	// Jobs waiting for a grader slot haven't started either.
+	queueHandler.Graders.Close()
+
+	// Jobs that haven't started go back to the queue for other dispatchers.
+	for _, d := range scheduler.Drain() {
+		queueDelivery.Requeue(d)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		pool.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("all gradings finished")
+	case <-time.After(*gracePeriod):
+		log.Println("grace period expired, requeue running gradings")
+		abort()
+		<-done
+	}
+}
+
+// routeConsumers feeds the scheduler from a consumer per grader route.
+type routeConsumers struct {
+	broker    *queue.Connection
+	scheduler *queueService.FairScheduler
+
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	tags    map[string]bool
+	stopped bool
+}
+
+// start consumes the queue of the route unless it is consumed already.
+func (c *routeConsumers) start(routingKey string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name := queue.QueueName(routingKey)
+	if c.stopped || c.tags[name] {
+		return nil
+	}
+
+	// The queue is declared on connect too, this is for a route new while
+	// connected.
+	ch, err := c.broker.Channel()
+	if err != nil && err != queue.ErrNotConnected {
+		return err
+	}
+	if err == nil {
+		_, err = queue.DeclareRoute(ch, routingKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	deliveries, err := c.broker.Consume(name, name)
+	if err != nil {
+		return err
+	}
+
+	c.tags[name] = true
+	c.wg.Add(1)
+
+	go func() {
+		defer c.wg.Done()
+		for d := range deliveries {
+			c.scheduler.Push(d)
+		}
+	}()
+
+	log.Printf("consuming %s", name)
+
+	return nil
+}
+
+// stop cancels the consumers and waits for their deliveries to be pushed.
+func (c *routeConsumers) stop(logger *zap.Logger) {
+	c.mu.Lock()
+	c.stopped = true
+	c.mu.Unlock()
+
+	for tag := range c.tags {
+		err := c.broker.Cancel(tag)
+		if err != nil {
+			logger.Error("cant cancel consumer", zap.String("consumer", tag), zap.Error(err))
+		}
+	}
+
+	c.wg.Wait()
 }
@@ -0,0 +1,514 @@
+// Package bundle is the portable form of a task: a directory or a zip
+// archive holding
+//
+//	task.json     the manifest: name, grading spec, submission policy, files
+//	statement.md  the statement in Markdown
+//	files/        assets, attachments, tests and the reference solution
+//
+// Bundles carry no ids, so they move between Grader instances and live in
+// git next to the course they belong to.
+package bundle
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"grader/pkg/queue"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	ManifestName  = "task.json"
+	StatementName = "statement.md"
+	FilesDir      = "files"
+	// Format is the manifest version this package reads and writes.
+	Format = 1
+	// MaxSize bounds the unpacked size of a bundle.
+	MaxSize = 100 << 20
+)
+
+var ErrBadBundle = errors.New("bad task bundle")
+
+type Manifest struct {
+	Format  int                `json:"format"`
+	Name    string             `json:"name"`
+	Grading queue.Capabilities `json:"grading"`
+	Policy  Policy             `json:"policy"`
+	Files   []File             `json:"files,omitempty"`
+}
+
+// Policy is the submission policy of the task, deadlines are left out
+// when there are none.
+type Policy struct {
+	LatestOnly   bool       `json:"latest_only,omitempty"`
+	SoftDeadline *time.Time `json:"soft_deadline,omitempty"`
+	HardDeadline *time.Time `json:"hard_deadline,omitempty"`
+	LatePolicy   string     `json:"late_policy,omitempty"`
+	Penalty      int        `json:"penalty,omitempty"`
+}
+
+type File struct {
+	Name string `json:"name"`
+	// Attachment files are offered for download on the task page.
+	Attachment bool `json:"attachment,omitempty"`
+	// Private files, like tests and the reference solution, are for
+	// staff only.
+	Private bool `json:"private,omitempty"`
+	// Role marks the tests and the reference solution, see the roles.
+	Role string `json:"role,omitempty"`
+	// Weight is the share of the score a test is worth.
+	Weight int `json:"weight,omitempty"`
+}
+
+// Roles of a file in grading, tests and the reference solution are
+// private whatever the manifest says.
+const (
+	RoleTest      = "test"
+	RoleReference = "reference"
+)
+
+type Bundle struct {
+	Manifest  Manifest
+	Statement string
+	// Files by name, each listed in the manifest.
+	Files map[string][]byte
+}
+
+// Validate checks the manifest against the files.
+func (b *Bundle) Validate() error {
+	if b.Manifest.Format != Format {
+		return fmt.Errorf("%w: format %d, want %d", ErrBadBundle, b.Manifest.Format, Format)
+	}
+	if strings.TrimSpace(b.Manifest.Name) == "" {
+		return fmt.Errorf("%w: no name", ErrBadBundle)
+	}
+
+	seen := map[string]bool{}
+	references := 0
+	for _, f := range b.Manifest.Files {
+		if f.Name == "" || f.Name == "." || f.Name == ".." || strings.ContainsAny(f.Name, "/\\") {
+			return fmt.Errorf("%w: bad file name %q", ErrBadBundle, f.Name)
+		}
+		if seen[f.Name] {
+			return fmt.Errorf("%w: %s listed twice", ErrBadBundle, f.Name)
+		}
+		seen[f.Name] = true
+
+		if _, ok := b.Files[f.Name]; !ok {
+			return fmt.Errorf("%w: %s is listed but missing", ErrBadBundle, f.Name)
+		}
+
+		if f.Role != "" && f.Role != RoleTest && f.Role != RoleReference {
+			return fmt.Errorf("%w: %s: unknown role %q", ErrBadBundle, f.Name, f.Role)
+		}
+		if f.Weight < 0 || f.Weight > 0 && f.Role != RoleTest {
+			return fmt.Errorf("%w: %s: only tests have a weight, not a negative one", ErrBadBundle, f.Name)
+		}
+		if f.Role == RoleReference {
+			references++
+			if references > 1 {
+				return fmt.Errorf("%w: %s: a second reference solution", ErrBadBundle, f.Name)
+			}
+		}
+	}
+	for name := range b.Files {
+		if !seen[name] {
+			return fmt.Errorf("%w: %s is not listed", ErrBadBundle, name)
+		}
+	}
+
+	return nil
+}
+
+// list adds the files the manifest doesn't mention yet, as plain assets,
+// so files dropped into a bundle by hand are picked up.
+func (b *Bundle) list() {
+	listed := map[string]bool{}
+	for _, f := range b.Manifest.Files {
+		listed[f.Name] = true
+	}
+
+	var names []string
+	for name := range b.Files {
+		if !listed[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		b.Manifest.Files = append(b.Manifest.Files, File{Name: name})
+	}
+}
+
+// Read unpacks a zipped bundle. The bundle may sit in a single top
+// directory, as archivers like to put it.
+func Read(r io.ReaderAt, size int64) (*Bundle, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadBundle, err)
+	}
+
+	prefix := ""
+	for _, f := range zr.File {
+		if path.Base(f.Name) == ManifestName && strings.Count(f.Name, "/") <= 1 {
+			prefix = strings.TrimSuffix(f.Name, ManifestName)
+			break
+		}
+	}
+
+	entries := map[string][]byte{}
+	var total int64
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !strings.HasPrefix(f.Name, prefix) {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadBundle, err)
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, MaxSize-total+1))
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadBundle, err)
+		}
+
+		total += int64(len(data))
+		if total > MaxSize {
+			return nil, fmt.Errorf("%w: larger than %d bytes", ErrBadBundle, MaxSize)
+		}
+
+		entries[strings.TrimPrefix(f.Name, prefix)] = data
+	}
+
+	return fromEntries(entries)
+}
+
+// ReadDir loads a bundle from a directory.
+func ReadDir(dir string) (*Bundle, error) {
+	entries := map[string][]byte{}
+	var total int64
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Dot files are the business of git and editors, not the task.
+		if p != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		total += int64(len(data))
+		if total > MaxSize {
+			return fmt.Errorf("%w: larger than %d bytes", ErrBadBundle, MaxSize)
+		}
+
+		entries[filepath.ToSlash(rel)] = data
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return fromEntries(entries)
+}
+
+func fromEntries(entries map[string][]byte) (*Bundle, error) {
+	manifest, ok := entries[ManifestName]
+	if !ok {
+		return nil, fmt.Errorf("%w: no %s", ErrBadBundle, ManifestName)
+	}
+
+	b := &Bundle{
+		Statement: string(entries[StatementName]),
+		Files:     map[string][]byte{},
+	}
+
+	err := json.Unmarshal(manifest, &b.Manifest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrBadBundle, ManifestName, err)
+	}
+
+	for name, data := range entries {
+		rel := strings.TrimPrefix(name, FilesDir+"/")
+		if rel == name {
+			continue
+		}
+		if strings.Contains(rel, "/") {
+			return nil, fmt.Errorf("%w: %s: files are not nested", ErrBadBundle, name)
+		}
+
+		b.Files[rel] = data
+	}
+
+	b.list()
+
+	return b, b.Validate()
+}
+
+// Write zips the bundle.
+func (b *Bundle) Write(w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	err := b.zip(zw, "")
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+func (b *Bundle) zip(zw *zip.Writer, prefix string) error {
+	return b.each(func(name string, data []byte) error {
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   prefix + name,
+			Method: zip.Deflate,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = f.Write(data)
+		return err
+	})
+}
+
+// WriteDir puts the bundle into dir, replacing the files it had there.
+// Files the bundle no longer has are removed, so the directory can be
+// committed as is.
+func (b *Bundle) WriteDir(dir string) error {
+	err := os.RemoveAll(filepath.Join(dir, FilesDir))
+	if err != nil {
+		return err
+	}
+
+	return b.each(func(name string, data []byte) error {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+
+		err := os.MkdirAll(filepath.Dir(p), 0750)
+		if err != nil {
+			return err
+		}
+
+		return os.WriteFile(p, data, 0640)
+	})
+}
+
+func (b *Bundle) each(fn func(string, []byte) error) error {
+	manifest, err := json.MarshalIndent(&b.Manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = fn(ManifestName, append(manifest, '\n'))
+	if err != nil {
+		return err
+	}
+
+	err = fn(StatementName, []byte(b.Statement))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range b.Manifest.Files {
+		err = fn(FilesDir+"/"+f.Name, b.Files[f.Name])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Bytes zips the bundle into memory.
+func (b *Bundle) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+
+	err := b.Write(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Course bundles are zips or directories holding a bundle per task, each
+// in a directory of its own named after the task.
+
+// WriteCourse zips the bundles, by directory name.
+func WriteCourse(w io.Writer, bundles map[string]*Bundle) error {
+	zw := zip.NewWriter(w)
+
+	for _, dir := range sortedKeys(bundles) {
+		err := bundles[dir].zip(zw, dir+"/")
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// ReadCourse unpacks a zip of WriteCourse. The task directories may sit
+// in a single top directory, as archivers like to put them.
+func ReadCourse(r io.ReaderAt, size int64) (map[string]*Bundle, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadBundle, err)
+	}
+
+	prefix := wrapper(zr.File)
+
+	entries := map[string]map[string][]byte{}
+	var total int64
+	for _, f := range zr.File {
+		dir, name, ok := strings.Cut(strings.TrimPrefix(f.Name, prefix), "/")
+		if !ok || f.FileInfo().IsDir() {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadBundle, err)
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, MaxSize-total+1))
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadBundle, err)
+		}
+
+		total += int64(len(data))
+		if total > MaxSize {
+			return nil, fmt.Errorf("%w: larger than %d bytes", ErrBadBundle, MaxSize)
+		}
+
+		if entries[dir] == nil {
+			entries[dir] = map[string][]byte{}
+		}
+		entries[dir][name] = data
+	}
+
+	bundles := map[string]*Bundle{}
+	for dir, e := range entries {
+		bundles[dir], err = fromEntries(e)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dir, err)
+		}
+	}
+
+	return bundles, nil
+}
+
+// wrapper is the top directory every entry of a course zip sits in, with
+// the slash, or empty when there is none. A course of one task has a
+// single top directory too, its manifest tells it from a wrapper.
+func wrapper(files []*zip.File) string {
+	top := ""
+	for _, f := range files {
+		dir, _, ok := strings.Cut(f.Name, "/")
+		if !ok || top != "" && dir != top || f.Name == dir+"/"+ManifestName {
+			return ""
+		}
+		top = dir
+	}
+
+	if top == "" {
+		return ""
+	}
+
+	return top + "/"
+}
+
+// ReadCourseDir loads the bundles of the subdirectories of dir that hold
+// a manifest.
+func ReadCourseDir(dir string) (map[string]*Bundle, error) {
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	bundles := map[string]*Bundle{}
+	for _, de := range des {
+		if !de.IsDir() {
+			continue
+		}
+
+		sub := filepath.Join(dir, de.Name())
+		if _, err = os.Stat(filepath.Join(sub, ManifestName)); err != nil {
+			continue
+		}
+
+		bundles[de.Name()], err = ReadDir(sub)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", de.Name(), err)
+		}
+	}
+
+	return bundles, nil
+}
+
+// Dir names the directory of a task in a course bundle, taken is the set
+// of names already given.
+func Dir(name string, taken map[string]bool) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r > 127 && unicode.IsLetter(r) {
+			sb.WriteRune(r)
+			dash = false
+		} else if !dash && sb.Len() > 0 {
+			sb.WriteByte('-')
+			dash = true
+		}
+	}
+
+	dir := strings.TrimSuffix(sb.String(), "-")
+	if dir == "" {
+		dir = "task"
+	}
+
+	unique := dir
+	for i := 2; taken[unique]; i++ {
+		unique = fmt.Sprintf("%s-%d", dir, i)
+	}
+	taken[unique] = true
+
+	return unique
+}
+
+func sortedKeys(m map[string]*Bundle) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
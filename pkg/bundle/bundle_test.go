@@ -0,0 +1,358 @@
+package bundle
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"grader/pkg/queue"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testBundle(name string) *Bundle {
+	deadline := time.Date(2024, 3, 1, 23, 59, 0, 0, time.UTC)
+
+	return &Bundle{
+		Manifest: Manifest{
+			Format: Format,
+			Name:   name,
+			Grading: queue.Capabilities{
+				Language:    "go",
+				Image:       "golangcourse_final",
+				LargeMemory: true,
+				Limits:      queue.Limits{TimeLimit: 30, MemoryLimit: 1024},
+			},
+			Policy: Policy{
+				LatestOnly:   true,
+				SoftDeadline: &deadline,
+				LatePolicy:   "linear",
+				Penalty:      10,
+			},
+			Files: []File{
+				{Name: "main.go", Attachment: true},
+				{Name: "main_test.go", Private: true, Role: RoleTest, Weight: 3},
+				{Name: "extra_test.go", Private: true, Role: RoleTest, Weight: 1},
+				{Name: "solution.go", Private: true, Role: RoleReference},
+			},
+		},
+		Statement: "# " + name + "\n\nWrite a program.\n",
+		Files: map[string][]byte{
+			"main.go":       []byte("package main\n"),
+			"main_test.go":  []byte("package main // test\n"),
+			"extra_test.go": []byte("package main // extra\n"),
+			"solution.go":   []byte("package main // solution\n"),
+		},
+	}
+}
+
+func equalBundles(t *testing.T, got, want *Bundle) {
+	t.Helper()
+
+	if !reflect.DeepEqual(got.Manifest, want.Manifest) {
+		t.Errorf("manifest = %+v, want %+v", got.Manifest, want.Manifest)
+	}
+	if got.Statement != want.Statement {
+		t.Errorf("statement = %q, want %q", got.Statement, want.Statement)
+	}
+	if !reflect.DeepEqual(got.Files, want.Files) {
+		t.Errorf("files = %v, want %v", got.Files, want.Files)
+	}
+}
+
+// zipOf zips the entries as they are, for hand made archives.
+func zipOf(t *testing.T, entries map[string]string) *bytes.Reader {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range entries {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.Write([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bytes.NewReader(buf.Bytes())
+}
+
+const manifest = `{"format": 1, "name": "Hello"}`
+
+func TestRoundTrip(t *testing.T) {
+	want := testBundle("Hello")
+
+	data, err := want.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() = %v", err)
+	}
+
+	got, err := Read(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+
+	equalBundles(t, got, want)
+}
+
+func TestRoundTripDir(t *testing.T) {
+	want := testBundle("Hello")
+	dir := t.TempDir()
+
+	err := want.WriteDir(dir)
+	if err != nil {
+		t.Fatalf("WriteDir() = %v", err)
+	}
+
+	got, err := ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() = %v", err)
+	}
+	equalBundles(t, got, want)
+
+	// Files dropped from the bundle go from the directory too.
+	want.Manifest.Files = want.Manifest.Files[:1]
+	want.Files = map[string][]byte{"main.go": want.Files["main.go"]}
+
+	err = want.WriteDir(dir)
+	if err != nil {
+		t.Fatalf("WriteDir() again = %v", err)
+	}
+
+	got, err = ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() again = %v", err)
+	}
+	equalBundles(t, got, want)
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries map[string]string
+		files   []File
+		wantErr bool
+	}{
+		{
+			name: "flat",
+			entries: map[string]string{
+				"task.json":     manifest,
+				"statement.md":  "hi",
+				"files/main.go": "package main",
+			},
+			files: []File{{Name: "main.go"}},
+		},
+		{
+			name: "in a top directory",
+			entries: map[string]string{
+				"hello/task.json":     manifest,
+				"hello/statement.md":  "hi",
+				"hello/files/main.go": "package main",
+			},
+			files: []File{{Name: "main.go"}},
+		},
+		{
+			name:    "no manifest",
+			entries: map[string]string{"statement.md": "hi"},
+			wantErr: true,
+		},
+		{
+			name: "nested file",
+			entries: map[string]string{
+				"task.json":      manifest,
+				"files/sub/a.go": "package a",
+			},
+			wantErr: true,
+		},
+		{
+			name: "listed but missing",
+			entries: map[string]string{
+				"task.json": `{"format": 1, "name": "Hello", "files": [{"name": "main.go"}]}`,
+			},
+			wantErr: true,
+		},
+		{
+			name: "other format",
+			entries: map[string]string{
+				"task.json": `{"format": 2, "name": "Hello"}`,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := zipOf(t, tt.entries)
+
+			b, err := Read(r, r.Size())
+			if tt.wantErr {
+				if !errors.Is(err, ErrBadBundle) {
+					t.Fatalf("Read() = %v, want %v", err, ErrBadBundle)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Read() = %v", err)
+			}
+
+			if !reflect.DeepEqual(b.Manifest.Files, tt.files) {
+				t.Errorf("files = %+v, want %+v", b.Manifest.Files, tt.files)
+			}
+			if b.Statement != "hi" {
+				t.Errorf("statement = %q, want hi", b.Statement)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(*Bundle)
+		ok   bool
+	}{
+		{name: "valid", edit: func(*Bundle) {}, ok: true},
+		{name: "no name", edit: func(b *Bundle) { b.Manifest.Name = " " }},
+		{name: "path in a name", edit: func(b *Bundle) {
+			b.Manifest.Files[0].Name = "../main.go"
+			b.Files["../main.go"] = nil
+		}},
+		{name: "listed twice", edit: func(b *Bundle) {
+			b.Manifest.Files = append(b.Manifest.Files, b.Manifest.Files[0])
+		}},
+		{name: "not listed", edit: func(b *Bundle) { b.Files["other.go"] = nil }},
+		{name: "unknown role", edit: func(b *Bundle) { b.Manifest.Files[0].Role = "checker" }},
+		{name: "weight on a plain file", edit: func(b *Bundle) { b.Manifest.Files[0].Weight = 1 }},
+		{name: "negative weight", edit: func(b *Bundle) { b.Manifest.Files[1].Weight = -1 }},
+		{name: "two references", edit: func(b *Bundle) { b.Manifest.Files[0].Role = RoleReference }},
+	}
+
+	for _, tt := range tests {
+		b := testBundle("Hello")
+		tt.edit(b)
+
+		err := b.Validate()
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+		if err != nil && !errors.Is(err, ErrBadBundle) {
+			t.Errorf("%s: Validate() = %v, want %v", tt.name, err, ErrBadBundle)
+		}
+	}
+}
+
+func TestCourseRoundTrip(t *testing.T) {
+	want := map[string]*Bundle{
+		"hello": testBundle("Hello"),
+		"world": testBundle("World"),
+	}
+
+	var buf bytes.Buffer
+	err := WriteCourse(&buf, want)
+	if err != nil {
+		t.Fatalf("WriteCourse() = %v", err)
+	}
+
+	got, err := ReadCourse(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("ReadCourse() = %v", err)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("ReadCourse() = %d bundles, want %d", len(got), len(want))
+	}
+	for dir, b := range want {
+		if got[dir] == nil {
+			t.Fatalf("no bundle in %s", dir)
+		}
+		equalBundles(t, got[dir], b)
+	}
+}
+
+func TestReadCourse(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries map[string]string
+		dirs    []string
+	}{
+		{
+			name: "task directories",
+			entries: map[string]string{
+				"hello/task.json": manifest,
+				"world/task.json": manifest,
+			},
+			dirs: []string{"hello", "world"},
+		},
+		{
+			name: "in a top directory",
+			entries: map[string]string{
+				"course/":                   "",
+				"course/hello/task.json":    manifest,
+				"course/hello/statement.md": "hi",
+				"course/world/task.json":    manifest,
+				"course/world/files/a.go":   "package a",
+			},
+			dirs: []string{"hello", "world"},
+		},
+		{
+			name: "one task",
+			entries: map[string]string{
+				"hello/task.json":     manifest,
+				"hello/files/main.go": "package main",
+			},
+			dirs: []string{"hello"},
+		},
+		{
+			name: "one task in a top directory",
+			entries: map[string]string{
+				"course/hello/task.json":     manifest,
+				"course/hello/files/main.go": "package main",
+			},
+			dirs: []string{"hello"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := zipOf(t, tt.entries)
+
+			bundles, err := ReadCourse(r, r.Size())
+			if err != nil {
+				t.Fatalf("ReadCourse() = %v", err)
+			}
+
+			if got := sortedKeys(bundles); !reflect.DeepEqual(got, tt.dirs) {
+				t.Errorf("directories = %v, want %v", got, tt.dirs)
+			}
+		})
+	}
+}
+
+func TestDir(t *testing.T) {
+	taken := map[string]bool{}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Hello, World!", "hello-world"},
+		{"hello world", "hello-world-2"},
+		{"Задача 1", "задача-1"},
+		{"!!!", "task"},
+		{"", "task-2"},
+	}
+
+	for _, tt := range tests {
+		got := Dir(tt.name, taken)
+		if got != tt.want {
+			t.Errorf("Dir(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
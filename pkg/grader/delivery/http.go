@@ -2,107 +2,214 @@ package delivery
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
+	"grader/pkg/grader"
 	"grader/pkg/grader/service"
-	"grader/pkg/server/solution"
+	"grader/pkg/graderauth"
+	"grader/pkg/queue"
+	"grader/pkg/server/blob"
+	"grader/pkg/tracing"
 	"grader/pkg/utils"
 	"io"
 	"net/http"
-	"net/url"
+	"strconv"
+	"time"
 )
 
+// errRejected is the server refusing a report, for a running report it
+// means the solution was cancelled or graded already.
+var errRejected = errors.New("rejected by server")
+
 type GraderHandler struct {
 	GraderService service.GraderServiceInterface
 	Logger        *zap.Logger
-	FormData      url.Values
+	// Signer signs the requests to the server webhooks.
+	Signer     *graderauth.Signer
+	ServerAddr string
+	// Host names this grader in status events and results.
+	Host string
 }
 
 func (h *GraderHandler) GradeSolution(w http.ResponseWriter, r *http.Request) {
-	s := &solution.Solution{}
+	ctx, span := tracing.Start(tracing.ExtractHTTP(r.Context(), r.Header), "GradeSolution")
+	defer span.End()
+
+	job := &queue.Job{}
 	client := &http.Client{}
 
-	err := utils.DecodeJSONHandler(w, r, &s)
+	err := utils.DecodeJSONHandler(w, r, job)
 	if err != nil {
 		h.Logger.Error("Failed to decode JSON", zap.Error(err))
 		return
 	}
 
-	result, err := h.GraderService.GradeFile(s.File)
+	if job.Version != queue.JobVersion {
+		h.Logger.Error("Unsupported job version", zap.Int("version", job.Version))
+		http.Error(w, "unsupported job version", http.StatusBadRequest)
+		return
+	}
+
+	span.SetAttributes(
+		attribute.Int("solution.id", job.SolutionID),
+		attribute.Int("solution.attempt", job.Attempt),
+		attribute.Int("task.id", job.TaskID),
+	)
+
+	if !h.GraderService.TryAcquire() {
+		h.Logger.Warn("Grader is full", zap.Int("solution", job.SolutionID))
+		http.Error(w, "grader is full", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.GraderService.Release()
+
+	content, err := h.fetchBlob(ctx, client, job.ContentHash)
 	if err != nil {
-		h.Logger.Error("Failed to grade file", zap.Error(err))
-		http.Error(w, "error grade file", http.StatusInternalServerError)
+		tracing.Fail(span, err)
+		h.Logger.Error("Failed to fetch solution file", zap.Int("solution", job.SolutionID), zap.Error(err))
+		http.Error(w, "error fetch solution file", http.StatusInternalServerError)
 		return
 	}
 
-	s.Result = result
-	s.Status = "completed"
+	err = h.postServer(ctx, client, "/webhook/solution/status", &queue.StatusEvent{
+		Version:    queue.JobVersion,
+		SolutionID: job.SolutionID,
+		Attempt:    job.Attempt,
+		Status:     queue.StatusRunning,
+		Host:       h.Host,
+	})
+	if err == errRejected {
+		h.Logger.Info("Skip job the server no longer waits for", zap.Int("solution", job.SolutionID), zap.Int("attempt", job.Attempt))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err != nil {
+		// Progress reports are informational, grade anyway.
+		h.Logger.Warn("Failed to report running status", zap.Int("solution", job.SolutionID), zap.Error(err))
+	}
 
-	resultData, err := json.Marshal(s)
+	taskID := strconv.Itoa(job.TaskID)
+	started := time.Now()
+
+	result, err := h.GraderService.GradeFile(ctx, job.FileName, content, job.Capabilities)
+	if errors.Is(err, context.Canceled) {
+		grader.VerdictsTotal.WithLabelValues(taskID, "cancelled").Inc()
+		h.Logger.Warn("Grading interrupted", zap.Int("solution", job.SolutionID))
+		http.Error(w, "grading interrupted", http.StatusServiceUnavailable)
+		return
+	}
 	if err != nil {
-		h.Logger.Error("Failed to marshal solution", zap.Error(err))
-		http.Error(w, "Failed to marshal solution:", http.StatusInternalServerError)
+		grader.VerdictsTotal.WithLabelValues(taskID, "error").Inc()
+		tracing.Fail(span, err)
+		h.Logger.Error("Failed to grade file", zap.Error(err))
+		http.Error(w, "error grade file", http.StatusInternalServerError)
 		return
 	}
 
-	req, err := http.NewRequest("POST", "http://localhost:3000/webhook/solution/result", bytes.NewBuffer(resultData))
+	grader.GradingDuration.WithLabelValues(taskID).Observe(time.Since(started).Seconds())
+
+	verdict := "fail"
+	if result.Pass {
+		verdict = "pass"
+	}
+	grader.VerdictsTotal.WithLabelValues(taskID, verdict).Inc()
+
+	result.Version = queue.JobVersion
+	result.SolutionID = job.SolutionID
+	result.Attempt = job.Attempt
+	result.Host = h.Host
+
+	span.SetAttributes(attribute.Bool("solution.pass", result.Pass))
+
+	err = h.postServer(ctx, client, "/webhook/solution/result", result)
 	if err != nil {
+		tracing.Fail(span, err)
 		h.Logger.Error("Failed to send webhook request:", zap.Error(err))
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		http.Error(w, "Failed to send webhook request", http.StatusInternalServerError)
 		return
 	}
+}
 
-	token, err := h.GraderService.GetToken()
+// postServer sends v as JSON to the server webhook at path.
+func (h *GraderHandler) postServer(ctx context.Context, client *http.Client, path string, v interface{}) error {
+	data, err := json.Marshal(v)
 	if err != nil {
-		h.Logger.Error("Failed to get token:", zap.Error(err))
-		http.Error(w, "failed to get token", http.StatusInternalServerError)
-		return
+		return err
 	}
-	cookie := http.Cookie{
-		Name:  "token",
-		Value: token,
+
+	req, err := http.NewRequest("POST", h.ServerAddr+path, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	tracing.InjectHTTP(ctx, req.Header)
+
+	err = h.Signer.Sign(req, data)
+	if err != nil {
+		return err
 	}
 
-	req.AddCookie(&cookie)
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := client.Do(req)
 	if err != nil {
-		h.Logger.Error("Failed to send webhook request:", zap.Error(err))
-		http.Error(w, "Failed to send webhook request", http.StatusInternalServerError)
-		return
+		return err
 	}
 	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		return errRejected
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected webhook status %d", resp.StatusCode)
+	}
+
+	return nil
 }
 
-func (h *GraderHandler) GraderLogin() {
-	resp, err := http.PostForm("http://localhost:3000/api/v1/user/login", h.FormData)
+// fetchBlob downloads the solution file from the server blob store and
+// checks it against the content hash from the job.
+func (h *GraderHandler) fetchBlob(ctx context.Context, client *http.Client, hash string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", h.ServerAddr+"/webhook/blobs/"+hash, nil)
 	if err != nil {
-		h.Logger.Error("Could not login to server service", zap.Error(err))
-		return
+		return nil, err
+	}
+	tracing.InjectHTTP(ctx, req.Header)
+
+	err = h.Signer.Sign(req, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected blob status %d", resp.StatusCode)
+	}
+
+	content, err := io.ReadAll(resp.Body)
 	if err != nil {
-		h.Logger.Error("Failed to read response body", zap.Error(err))
-		return
+		return nil, err
 	}
 
-	if resp.StatusCode == http.StatusOK {
-		for _, cookie := range resp.Cookies() {
-			if cookie.Name == "token" {
-				err = h.GraderService.GraderLogin(cookie.Value)
-				if err != nil {
-					h.Logger.Error("Failed login with token", zap.String("token", cookie.Value))
-					return
-				}
-
-				return
-			}
-		}
-	} else {
-		h.Logger.Error("Failed to login", zap.String("responseBody", string(body)))
-		return
+	if blob.Hash(content) != hash {
+		return nil, fmt.Errorf("blob %s content hash mismatch", hash)
 	}
+
+	return content, nil
+}
+
+func (h *GraderHandler) Capabilities(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONHandler(w, h.GraderService.Capabilities(), http.StatusOK)
+}
+
+func (h *GraderHandler) Load(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONHandler(w, h.GraderService.Load(), http.StatusOK)
 }
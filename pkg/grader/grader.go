@@ -1,9 +1,15 @@
 package grader
 
+import "grader/pkg/queue"
+
 type Config struct {
-	ExternalGrader string       `json:"external_grader"`
-	Files          []FileConfig `json:"files"`
-	GraderPayload  Payload      `json:"grader_payload"`
+	ExternalGrader string                   `json:"external_grader"`
+	Files          []FileConfig             `json:"files"`
+	GraderPayload  Payload                  `json:"grader_payload"`
+	Capabilities   queue.GraderCapabilities `json:"capabilities"`
+	// Capacity is how many containers the host runs at once, 0 means one
+	// per CPU.
+	Capacity int `json:"capacity"`
 }
 
 type FileConfig struct {
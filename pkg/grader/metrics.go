@@ -0,0 +1,32 @@
+package grader
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	GradingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "grader",
+		Subsystem: "grader",
+		Name:      "grading_duration_seconds",
+		Help:      "Time to grade a solution, container run included.",
+		Buckets:   []float64{1, 2.5, 5, 10, 20, 30, 60, 120},
+	}, []string{"task_id"})
+
+	// VerdictsTotal counts grading outcomes: pass, fail, error or cancelled.
+	VerdictsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grader",
+		Subsystem: "grader",
+		Name:      "verdicts_total",
+		Help:      "Grading outcomes by task.",
+	}, []string{"task_id", "verdict"})
+
+	ContainerStartDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "grader",
+		Subsystem: "grader",
+		Name:      "container_start_seconds",
+		Help:      "Time from creating the container to it running.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 5, 10},
+	})
+)
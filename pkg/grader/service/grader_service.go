@@ -1,59 +1,110 @@
 package service
 
 import (
+	"context"
 	"fmt"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 	"grader/pkg/grader"
-	"grader/pkg/grader/repo"
-	"grader/pkg/server/solution"
+	"grader/pkg/queue"
+	"grader/pkg/tracing"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	standardMemory = "512m"
+	largeMemory    = "4g"
+)
+
 type GraderServiceInterface interface {
-	GradeFile(*solution.File) (*solution.Result, error)
-	GraderLogin(string) error
-	GetToken() (string, error)
+	GradeFile(context.Context, string, []byte, queue.Capabilities) (*queue.Result, error)
+	Capabilities() queue.GraderCapabilities
+	TryAcquire() bool
+	Release()
+	Load() queue.GraderLoad
 }
 
 type GraderService struct {
-	Config     *grader.Config
-	GraderRepo *repo.GraderRepo
+	Config *grader.Config
+
+	mu       *sync.Mutex
+	capacity int
+	running  int
 }
 
-func NewGraderService(config *grader.Config, graderRepo *repo.GraderRepo) *GraderService {
+func NewGraderService(config *grader.Config) *GraderService {
+	capacity := config.Capacity
+	if capacity <= 0 {
+		capacity = runtime.NumCPU()
+	}
+
 	return &GraderService{
-		Config:     config,
-		GraderRepo: graderRepo,
+		Config:   config,
+		mu:       &sync.Mutex{},
+		capacity: capacity,
 	}
 }
 
-func (s *GraderService) GraderLogin(token string) error {
-	err := s.GraderRepo.SaveToken(token)
-	if err != nil {
-		return err
+// TryAcquire takes a container slot, false means the host is full.
+func (s *GraderService) TryAcquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running >= s.capacity {
+		return false
 	}
+	s.running++
 
-	return nil
+	return true
 }
 
-func (s *GraderService) GetToken() (string, error) {
-	t, err := s.GraderRepo.GetToken()
-	if err != nil {
-		return "", err
+func (s *GraderService) Release() {
+	s.mu.Lock()
+	s.running--
+	s.mu.Unlock()
+}
+
+func (s *GraderService) Load() queue.GraderLoad {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return queue.GraderLoad{
+		Capacity: s.capacity,
+		Running:  s.running,
 	}
+}
 
-	return t, err
+func (s *GraderService) Capabilities() queue.GraderCapabilities {
+	return s.Config.Capabilities
 }
 
-func (s *GraderService) GradeFile(file *solution.File) (*solution.Result, error) {
+// GradeFile runs the solution in a container. Cancelling ctx kills the
+// container and returns ctx.Err().
+func (s *GraderService) GradeFile(ctx context.Context, name string, content []byte, caps queue.Capabilities) (*queue.Result, error) {
+	ctx, span := tracing.Start(ctx, "GradeFile", attribute.String("file.name", name))
+	defer span.End()
+
 	var fileName string
 
+	if caps.Image == "" {
+		caps.Image = s.Config.GraderPayload.Container
+	}
+	caps = caps.WithDefaults()
+
+	if !s.Config.Capabilities.Serves(caps) {
+		return nil, fmt.Errorf("grader can't serve %s", caps.RoutingKey())
+	}
+
 	for _, f := range s.Config.Files {
-		if f.FileName == file.FileName {
-			fileName = file.FileName
+		if f.FileName == name {
+			fileName = name
 			break
 		}
 	}
@@ -62,9 +113,7 @@ func (s *GraderService) GradeFile(file *solution.File) (*solution.Result, error)
 		return nil, fmt.Errorf("empty filename")
 	}
 
-	result := &solution.Result{}
-
-	contentBytes := []byte(file.File)
+	result := &queue.Result{}
 
 	tempDir, err := os.MkdirTemp("", "tempDir")
 	if err != nil {
@@ -74,7 +123,7 @@ func (s *GraderService) GradeFile(file *solution.File) (*solution.Result, error)
 
 	tempFilePath := filepath.Join(tempDir, fileName)
 
-	err = os.WriteFile(tempFilePath, contentBytes, 0666)
+	err = os.WriteFile(tempFilePath, content, 0666)
 	if err != nil {
 		return nil, fmt.Errorf("failed to write file content to temporary file: %w", err)
 	}
@@ -84,27 +133,94 @@ func (s *GraderService) GradeFile(file *solution.File) (*solution.Result, error)
 
 	filePath := fmt.Sprintf("%s:/grader/solutionFiles/%s", tempFilePath, fileName)
 
-	cmd := exec.Command(
+	network := "none"
+	if caps.Network {
+		network = "bridge"
+	}
+
+	memory := standardMemory
+	if caps.LargeMemory {
+		memory = largeMemory
+	}
+	if caps.Limits.MemoryLimit > 0 {
+		memory = fmt.Sprintf("%dm", caps.Limits.MemoryLimit)
+	}
+
+	containerName := "run" + uuid.New().String()
+
+	// The container is created and started separately from waiting for it,
+	// so start latency can be told apart from the solution run time.
+	created := time.Now()
+	defer exec.Command("docker", "rm", "-f", containerName).Run()
+
+	err = exec.CommandContext(
+		ctx,
 		"docker",
-		"run",
+		"create",
 		"--user",
 		"1000",
 		"--network",
-		"none",
-		"--rm",
+		network,
+		"--memory",
+		memory,
 		"--name",
-		"runXXXXXXXXXXXX",
+		containerName,
 		"--stop-timeout",
 		stopTimeout,
 		"-v",
 		filePath,
-		s.Config.GraderPayload.Container,
+		caps.Image,
 		"partId",
 		s.Config.GraderPayload.PartID,
-	)
+	).Run()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container: %w", err)
+	}
+	err = exec.CommandContext(ctx, "docker", "start", containerName).Run()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to start container: %w", err)
+	}
+
+	grader.ContainerStartDuration.Observe(time.Since(created).Seconds())
+	span.AddEvent("container started")
+
+	// The time limit kills the run like a cancel does, but it is the
+	// solution's fault, so it gets a failed result.
+	timeLimit := caps.Limits.Timeout()
+	waitCtx, cancel := context.WithTimeout(ctx, timeLimit)
+	defer cancel()
+
+	wait := exec.CommandContext(waitCtx, "docker", "wait", containerName)
+	wait.Cancel = func() error {
+		return exec.Command("docker", "kill", containerName).Run()
+	}
+
+	exitCode, err := wait.Output()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if waitCtx.Err() != nil {
+		result.Pass = false
+		result.Text = fmt.Sprintf("Превышено ограничение времени: %d с", int(timeLimit/time.Second))
 
-	output, err := cmd.CombinedOutput()
+		return result, nil
+	}
 	if err != nil {
+		return nil, fmt.Errorf("failed to wait for container: %w", err)
+	}
+
+	output, err := exec.Command("docker", "logs", containerName).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read container logs: %w", err)
+	}
+
+	if strings.TrimSpace(string(exitCode)) != "0" {
 		result.Pass = false
 		result.Text = string(output)
 
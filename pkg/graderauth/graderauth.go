@@ -0,0 +1,102 @@
+// Package graderauth signs grader requests to the server webhooks with a
+// shared secret. A request carries the key id, a timestamp, a nonce and an
+// HMAC-SHA256 over them, the method, the path and the body hash, so the
+// server can tell it came from a registered grader and was not replayed.
+package graderauth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	KeyHeader       = "X-Grader-Key"
+	TimestampHeader = "X-Grader-Timestamp"
+	NonceHeader     = "X-Grader-Nonce"
+	SignatureHeader = "X-Grader-Signature"
+)
+
+var (
+	ErrUnsigned     = errors.New("request is not signed")
+	ErrUnknownKey   = errors.New("unknown grader key")
+	ErrBadSignature = errors.New("bad request signature")
+	ErrExpired      = errors.New("request timestamp out of range")
+	ErrReplayed     = errors.New("request nonce already used")
+	ErrTooLarge     = errors.New("request body too large")
+)
+
+// Keys maps key ids to secrets. The server accepts every key it holds, so
+// a key is rotated by adding the new one, moving graders to it and then
+// dropping the old one.
+type Keys map[string][]byte
+
+// ParseKeys reads keys written as "id:secret,id:secret".
+func ParseKeys(s string) (Keys, error) {
+	keys := make(Keys)
+
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		id, secret, ok := strings.Cut(pair, ":")
+		if !ok || id == "" || secret == "" {
+			return nil, fmt.Errorf("bad grader key %q, want id:secret", pair)
+		}
+		if _, dup := keys[id]; dup {
+			return nil, fmt.Errorf("duplicate grader key id %q", id)
+		}
+
+		keys[id] = []byte(secret)
+	}
+
+	return keys, nil
+}
+
+type Signer struct {
+	KeyID  string
+	Secret []byte
+}
+
+// Sign sets the signature headers on req, body must be what req sends.
+func (s *Signer) Sign(req *http.Request, body []byte) error {
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	n := hex.EncodeToString(nonce)
+
+	req.Header.Set(KeyHeader, s.KeyID)
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(NonceHeader, n)
+	req.Header.Set(SignatureHeader, signature(s.Secret, req.Method, req.URL.EscapedPath(), ts, n, body))
+
+	return nil
+}
+
+func signature(secret []byte, method, path, ts, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{
+		method,
+		path,
+		ts,
+		nonce,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
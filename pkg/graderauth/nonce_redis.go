@@ -0,0 +1,21 @@
+package graderauth
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+type RedisNonces struct {
+	client *redis.Client
+}
+
+func NewRedisNonces(client *redis.Client) *RedisNonces {
+	return &RedisNonces{
+		client: client,
+	}
+}
+
+func (n *RedisNonces) Use(ctx context.Context, keyID, nonce string, ttl time.Duration) (bool, error) {
+	return n.client.SetNX(ctx, "grader_nonce:"+keyID+":"+nonce, 1, ttl).Result()
+}
@@ -0,0 +1,92 @@
+package graderauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// MaxSkew is how far a request timestamp may be from the server clock.
+	MaxSkew = 5 * time.Minute
+	// maxBody caps what is read to check the body hash, results are small.
+	// Larger bodies are refused rather than cut, a cut body would fail the
+	// hash anyway.
+	maxBody = 1 << 20
+)
+
+// NonceStore remembers nonces for as long as their requests could pass the
+// timestamp check.
+type NonceStore interface {
+	// Use records the nonce, false means it was seen before.
+	Use(ctx context.Context, keyID, nonce string, ttl time.Duration) (bool, error)
+}
+
+type Verifier struct {
+	Keys   Keys
+	Nonces NonceStore
+}
+
+func NewVerifier(keys Keys, nonces NonceStore) *Verifier {
+	return &Verifier{
+		Keys:   keys,
+		Nonces: nonces,
+	}
+}
+
+// Verify checks the request signature. The body is read and put back for
+// the handler.
+func (v *Verifier) Verify(r *http.Request) error {
+	keyID := r.Header.Get(KeyHeader)
+	ts := r.Header.Get(TimestampHeader)
+	nonce := r.Header.Get(NonceHeader)
+	sig := r.Header.Get(SignatureHeader)
+
+	if keyID == "" || ts == "" || nonce == "" || sig == "" {
+		return ErrUnsigned
+	}
+
+	secret, ok := v.Keys[keyID]
+	if !ok {
+		return ErrUnknownKey
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrExpired
+	}
+	skew := time.Since(time.Unix(unix, 0))
+	if skew > MaxSkew || skew < -MaxSkew {
+		return ErrExpired
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxBody {
+		return ErrTooLarge
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	want := signature(secret, r.Method, r.URL.EscapedPath(), ts, nonce, body)
+	if !hmac.Equal([]byte(want), []byte(sig)) {
+		return ErrBadSignature
+	}
+
+	// Checked last so forged requests can't burn nonces.
+	fresh, err := v.Nonces.Use(r.Context(), keyID, nonce, 2*MaxSkew)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrReplayed
+	}
+
+	return nil
+}
@@ -0,0 +1,284 @@
+package graderauth
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memNonces is a NonceStore in memory, ttl is not enforced.
+type memNonces struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func newMemNonces() *memNonces {
+	return &memNonces{seen: map[string]bool{}}
+}
+
+func (n *memNonces) Use(_ context.Context, keyID, nonce string, _ time.Duration) (bool, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	key := keyID + ":" + nonce
+	if n.seen[key] {
+		return false, nil
+	}
+	n.seen[key] = true
+
+	return true, nil
+}
+
+func signedRequest(t *testing.T, s *Signer, body []byte) *http.Request {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/result", bytes.NewReader(body))
+	err := s.Sign(req, body)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	return req
+}
+
+// resign signs the request again at ts, for the clock skew cases.
+func resign(req *http.Request, s *Signer, ts time.Time, body []byte) {
+	unix := strconv.FormatInt(ts.Unix(), 10)
+	nonce := req.Header.Get(NonceHeader)
+
+	req.Header.Set(TimestampHeader, unix)
+	req.Header.Set(SignatureHeader, signature(s.Secret, req.Method, req.URL.EscapedPath(), unix, nonce, body))
+}
+
+func TestVerify(t *testing.T) {
+	keys := Keys{
+		"old": []byte("old-secret"),
+		"new": []byte("new-secret"),
+	}
+	body := []byte(`{"solution_id":1,"pass":true}`)
+
+	tests := []struct {
+		name   string
+		signer *Signer
+		edit   func(*http.Request)
+		want   error
+	}{
+		{
+			name:   "valid",
+			signer: &Signer{KeyID: "new", Secret: keys["new"]},
+		},
+		{
+			name:   "old key during rotation",
+			signer: &Signer{KeyID: "old", Secret: keys["old"]},
+		},
+		{
+			name:   "unknown key",
+			signer: &Signer{KeyID: "gone", Secret: []byte("gone-secret")},
+			want:   ErrUnknownKey,
+		},
+		{
+			name:   "secret of another key",
+			signer: &Signer{KeyID: "new", Secret: keys["old"]},
+			want:   ErrBadSignature,
+		},
+		{
+			name:   "unsigned",
+			signer: &Signer{KeyID: "new", Secret: keys["new"]},
+			edit: func(r *http.Request) {
+				r.Header.Del(SignatureHeader)
+			},
+			want: ErrUnsigned,
+		},
+		{
+			name:   "tampered body",
+			signer: &Signer{KeyID: "new", Secret: keys["new"]},
+			edit: func(r *http.Request) {
+				r.Body = io.NopCloser(bytes.NewReader([]byte(`{"solution_id":1,"pass":false}`)))
+			},
+			want: ErrBadSignature,
+		},
+		{
+			name:   "other path",
+			signer: &Signer{KeyID: "new", Secret: keys["new"]},
+			edit: func(r *http.Request) {
+				r.URL.Path = "/api/v1/status"
+			},
+			want: ErrBadSignature,
+		},
+		{
+			name:   "other method",
+			signer: &Signer{KeyID: "new", Secret: keys["new"]},
+			edit: func(r *http.Request) {
+				r.Method = http.MethodPut
+			},
+			want: ErrBadSignature,
+		},
+		{
+			name:   "bad timestamp",
+			signer: &Signer{KeyID: "new", Secret: keys["new"]},
+			edit: func(r *http.Request) {
+				r.Header.Set(TimestampHeader, "yesterday")
+			},
+			want: ErrExpired,
+		},
+		{
+			name:   "within skew behind",
+			signer: &Signer{KeyID: "new", Secret: keys["new"]},
+			edit: func(r *http.Request) {
+				resign(r, &Signer{KeyID: "new", Secret: keys["new"]}, time.Now().Add(-MaxSkew+time.Minute), body)
+			},
+		},
+		{
+			name:   "within skew ahead",
+			signer: &Signer{KeyID: "new", Secret: keys["new"]},
+			edit: func(r *http.Request) {
+				resign(r, &Signer{KeyID: "new", Secret: keys["new"]}, time.Now().Add(MaxSkew-time.Minute), body)
+			},
+		},
+		{
+			name:   "too old",
+			signer: &Signer{KeyID: "new", Secret: keys["new"]},
+			edit: func(r *http.Request) {
+				resign(r, &Signer{KeyID: "new", Secret: keys["new"]}, time.Now().Add(-MaxSkew-time.Minute), body)
+			},
+			want: ErrExpired,
+		},
+		{
+			name:   "too far ahead",
+			signer: &Signer{KeyID: "new", Secret: keys["new"]},
+			edit: func(r *http.Request) {
+				resign(r, &Signer{KeyID: "new", Secret: keys["new"]}, time.Now().Add(MaxSkew+time.Minute), body)
+			},
+			want: ErrExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewVerifier(keys, newMemNonces())
+
+			req := signedRequest(t, tt.signer, body)
+			if tt.edit != nil {
+				tt.edit(req)
+			}
+
+			err := v.Verify(req)
+			if err != tt.want {
+				t.Fatalf("Verify() = %v, want %v", err, tt.want)
+			}
+			if err != nil {
+				return
+			}
+
+			got, err := io.ReadAll(req.Body)
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+			if !bytes.Equal(got, body) {
+				t.Errorf("body after Verify = %q, want %q", got, body)
+			}
+		})
+	}
+}
+
+func TestVerifyReplay(t *testing.T) {
+	keys := Keys{"k": []byte("secret")}
+	s := &Signer{KeyID: "k", Secret: keys["k"]}
+	v := NewVerifier(keys, newMemNonces())
+	body := []byte(`{}`)
+
+	req := signedRequest(t, s, body)
+	replay := req.Clone(context.Background())
+	replay.Body = io.NopCloser(bytes.NewReader(body))
+
+	err := v.Verify(req)
+	if err != nil {
+		t.Fatalf("first Verify() = %v", err)
+	}
+
+	err = v.Verify(replay)
+	if err != ErrReplayed {
+		t.Fatalf("replayed Verify() = %v, want %v", err, ErrReplayed)
+	}
+}
+
+func TestVerifyForgeryKeepsNonce(t *testing.T) {
+	keys := Keys{"k": []byte("secret")}
+	s := &Signer{KeyID: "k", Secret: keys["k"]}
+	v := NewVerifier(keys, newMemNonces())
+	body := []byte(`{}`)
+
+	req := signedRequest(t, s, body)
+
+	forged := req.Clone(context.Background())
+	forged.Body = io.NopCloser(bytes.NewReader(body))
+	forged.Header.Set(SignatureHeader, "00")
+
+	err := v.Verify(forged)
+	if err != ErrBadSignature {
+		t.Fatalf("forged Verify() = %v, want %v", err, ErrBadSignature)
+	}
+
+	err = v.Verify(req)
+	if err != nil {
+		t.Fatalf("genuine Verify() after forgery = %v", err)
+	}
+}
+
+func TestVerifyBodySize(t *testing.T) {
+	keys := Keys{"k": []byte("secret")}
+	s := &Signer{KeyID: "k", Secret: keys["k"]}
+
+	tests := []struct {
+		name string
+		size int
+		want error
+	}{
+		{name: "at limit", size: maxBody},
+		{name: "over limit", size: maxBody + 1, want: ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewVerifier(keys, newMemNonces())
+
+			err := v.Verify(signedRequest(t, s, make([]byte, tt.size)))
+			if err != tt.want {
+				t.Fatalf("Verify() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{in: "", want: 0},
+		{in: "a:1", want: 1},
+		{in: " a:1 , b:2 ,", want: 2},
+		{in: "a", wantErr: true},
+		{in: "a:", wantErr: true},
+		{in: ":1", wantErr: true},
+		{in: "a:1,a:2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		keys, err := ParseKeys(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseKeys(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && len(keys) != tt.want {
+			t.Errorf("ParseKeys(%q) = %d keys, want %d", tt.in, len(keys), tt.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package markdown
+
+import (
+	"bytes"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	"html/template"
+	"net/url"
+	"strings"
+)
+
+var baseKey = parser.NewContextKey()
+
+// Statements written before Markdown are plain text, hard wraps keep
+// their line breaks. Code is highlighted with inline styles so the
+// sanitizer has no classes to trust.
+var md = goldmark.New(
+	goldmark.WithExtensions(
+		extension.Table,
+		extension.Strikethrough,
+		extension.Linkify,
+		highlighting.NewHighlighting(
+			highlighting.WithStyle("github"),
+		),
+	),
+	goldmark.WithParserOptions(
+		parser.WithASTTransformers(util.Prioritized(assetLinks{}, 100)),
+	),
+	goldmark.WithRendererOptions(
+		html.WithHardWraps(),
+	),
+)
+
+var policy = newPolicy()
+
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowStyles("color", "background-color", "font-weight", "font-style", "text-decoration").
+		OnElements("pre", "span")
+
+	return p
+}
+
+// Render turns Markdown into sanitized HTML. Relative links and images
+// point into base, where the assets of a task are served.
+func Render(src, base string) template.HTML {
+	ctx := parser.NewContext()
+	ctx.Set(baseKey, base)
+
+	var buf bytes.Buffer
+	err := md.Convert([]byte(src), &buf, parser.WithContext(ctx))
+	if err != nil {
+		return template.HTML(template.HTMLEscapeString(src))
+	}
+
+	return template.HTML(policy.SanitizeBytes(buf.Bytes()))
+}
+
+type assetLinks struct{}
+
+func (assetLinks) Transform(doc *ast.Document, _ text.Reader, pc parser.Context) {
+	base, _ := pc.Get(baseKey).(string)
+	if base == "" {
+		return
+	}
+
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch n := n.(type) {
+		case *ast.Image:
+			n.Destination = resolve(base, n.Destination)
+		case *ast.Link:
+			n.Destination = resolve(base, n.Destination)
+		}
+
+		return ast.WalkContinue, nil
+	})
+}
+
+// resolve puts a bare file name or relative path under base and leaves
+// absolute URLs, absolute paths and anchors as they are.
+func resolve(base string, dest []byte) []byte {
+	d := string(dest)
+	if d == "" || strings.HasPrefix(d, "/") || strings.HasPrefix(d, "#") {
+		return dest
+	}
+
+	u, err := url.Parse(d)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return dest
+	}
+
+	return []byte(base + strings.TrimPrefix(d, "./"))
+}
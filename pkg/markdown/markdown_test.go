@@ -0,0 +1,106 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderSanitizes(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "script tag",
+			src:     "hello <script>alert(1)</script>",
+			want:    []string{"hello"},
+			notWant: []string{"<script"},
+		},
+		{
+			name:    "event handler",
+			src:     `<img src="x.png" onerror="alert(1)">`,
+			notWant: []string{"onerror", "alert"},
+		},
+		{
+			name:    "javascript link",
+			src:     "[click](javascript:alert(1))",
+			want:    []string{"click"},
+			notWant: []string{"javascript:"},
+		},
+		{
+			name:    "iframe",
+			src:     `<iframe src="https://example.com"></iframe>`,
+			notWant: []string{"<iframe"},
+		},
+		{
+			name:    "style on a paragraph",
+			src:     `<p style="position:fixed">x</p>`,
+			notWant: []string{"position"},
+		},
+		{
+			name: "table",
+			src:  "| a | b |\n|---|---|\n| 1 | 2 |",
+			want: []string{"<table>", "<td>1</td>"},
+		},
+		{
+			name:    "highlighted code keeps inline colors only",
+			src:     "```go\nfunc main() {}\n```",
+			want:    []string{"<pre", "style=\"color:"},
+			notWant: []string{"class="},
+		},
+		{
+			name: "hard wraps",
+			src:  "line one\nline two",
+			want: []string{"line one<br"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(Render(tt.src, ""))
+
+			for _, s := range tt.want {
+				if !strings.Contains(got, s) {
+					t.Errorf("Render(%q) = %q, want it to contain %q", tt.src, got, s)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(got, s) {
+					t.Errorf("Render(%q) = %q, want no %q", tt.src, got, s)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderAssetLinks(t *testing.T) {
+	const base = "/tasks/7/assets/"
+
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{name: "image", src: "![plot](plot.png)", want: `src="/tasks/7/assets/plot.png"`},
+		{name: "dot slash", src: "[data](./data.csv)", want: `href="/tasks/7/assets/data.csv"`},
+		{name: "absolute url", src: "[go](https://go.dev/)", want: `href="https://go.dev/"`},
+		{name: "absolute path", src: "[home](/tasks)", want: `href="/tasks"`},
+		{name: "anchor", src: "[up](#top)", want: `href="#top"`},
+	}
+
+	for _, tt := range tests {
+		got := string(Render(tt.src, base))
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("%s: Render(%q) = %q, want it to contain %q", tt.name, tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestRenderWithoutBase(t *testing.T) {
+	got := string(Render("![plot](plot.png)", ""))
+	if !strings.Contains(got, `src="plot.png"`) {
+		t.Errorf("Render() = %q, want the link left as is", got)
+	}
+}
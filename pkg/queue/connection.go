@@ -0,0 +1,342 @@
+package queue
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+var (
+	ErrNotConnected = errors.New("not connected to rabbit")
+	ErrClosed       = errors.New("rabbit connection closed")
+)
+
+type consumer struct {
+	queue     string
+	tag       string
+	out       chan amqp.Delivery
+	cancelled bool
+	outClosed bool
+	// forwarders counts goroutines still sending to out, an old one may
+	// outlive a reconnect for a moment.
+	forwarders int
+}
+
+// Connection keeps a RabbitMQ connection alive. After every (re)connect it
+// runs the registered setup funcs and resumes the registered consumers.
+type Connection struct {
+	addr   string
+	logger *zap.Logger
+
+	mu        *sync.Mutex
+	conn      *amqp.Connection
+	ch        *amqp.Channel
+	setups    []func(*amqp.Channel) error
+	consumers map[string]*consumer
+	closed    bool
+	done      chan struct{}
+}
+
+func NewConnection(addr string, logger *zap.Logger) *Connection {
+	return &Connection{
+		addr:      addr,
+		logger:    logger,
+		mu:        &sync.Mutex{},
+		consumers: make(map[string]*consumer),
+		done:      make(chan struct{}),
+	}
+}
+
+// OnConnect registers a func declaring exchanges, queues or QoS. It must be
+// called before Run.
+func (c *Connection) OnConnect(setup func(*amqp.Channel) error) {
+	c.mu.Lock()
+	c.setups = append(c.setups, setup)
+	c.mu.Unlock()
+}
+
+// Run connects and reconnects with backoff until Close is called.
+func (c *Connection) Run() {
+	delay := minReconnectDelay
+
+	for {
+		connClosed, chanClosed, err := c.connect()
+		if err == ErrClosed {
+			return
+		}
+		if err != nil {
+			c.logger.Error("cant connect to rabbit", zap.Error(err), zap.Duration("retry", delay))
+
+			select {
+			case <-c.done:
+				return
+			case <-time.After(delay):
+			}
+
+			delay *= 2
+			if delay > maxReconnectDelay {
+				delay = maxReconnectDelay
+			}
+			continue
+		}
+
+		delay = minReconnectDelay
+		c.logger.Info("rabbit connected")
+
+		select {
+		case <-c.done:
+			return
+		case err := <-connClosed:
+			c.logger.Error("rabbit connection closed", zap.Any("reason", err))
+		case err := <-chanClosed:
+			c.logger.Error("rabbit channel closed", zap.Any("reason", err))
+		}
+
+		c.disconnect()
+	}
+}
+
+func (c *Connection) connect() (chan *amqp.Error, chan *amqp.Error, error) {
+	conn, err := amqp.Dial(c.addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		conn.Close()
+		return nil, nil, ErrClosed
+	}
+
+	for _, setup := range c.setups {
+		err = setup(ch)
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+
+	for _, cons := range c.consumers {
+		if cons.cancelled {
+			continue
+		}
+
+		err = c.startConsumer(ch, cons)
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+
+	c.conn = conn
+	c.ch = ch
+
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	chanClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	return connClosed, chanClosed, nil
+}
+
+func (c *Connection) disconnect() {
+	c.mu.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.ch = nil
+	c.mu.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// startConsumer must be called with c.mu held.
+func (c *Connection) startConsumer(ch *amqp.Channel, cons *consumer) error {
+	deliveries, err := ch.Consume(
+		cons.queue, // queue
+		cons.tag,   // consumer
+		false,      // auto-ack
+		false,      // exclusive
+		false,      // no-local
+		false,      // no-wait
+		nil,        // args
+	)
+	if err != nil {
+		return err
+	}
+
+	cons.forwarders++
+	go c.forward(cons, deliveries)
+
+	return nil
+}
+
+func (c *Connection) forward(cons *consumer, deliveries <-chan amqp.Delivery) {
+	for d := range deliveries {
+		ConsumedTotal.WithLabelValues(cons.queue).Inc()
+		cons.out <- d
+	}
+
+	c.mu.Lock()
+	cons.forwarders--
+	if cons.forwarders == 0 && (cons.cancelled || c.closed) && !cons.outClosed {
+		close(cons.out)
+		cons.outClosed = true
+	}
+	c.mu.Unlock()
+}
+
+// Consume returns deliveries from queue that survive reconnects. The
+// channel is closed after Cancel or Close.
+func (c *Connection) Consume(queue, tag string) (<-chan amqp.Delivery, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+
+	cons := &consumer{
+		queue: queue,
+		tag:   tag,
+		out:   make(chan amqp.Delivery),
+	}
+	c.consumers[tag] = cons
+
+	if c.ch != nil {
+		err := c.startConsumer(c.ch, cons)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return cons.out, nil
+}
+
+// Cancel stops the consumer, its channel is closed once the deliveries
+// already received are handed out.
+func (c *Connection) Cancel(tag string) error {
+	c.mu.Lock()
+	cons, ok := c.consumers[tag]
+	if !ok {
+		c.mu.Unlock()
+		return nil
+	}
+
+	cons.cancelled = true
+	ch := c.ch
+	active := cons.forwarders > 0
+	if !active && !cons.outClosed {
+		close(cons.out)
+		cons.outClosed = true
+	}
+	c.mu.Unlock()
+
+	if active && ch != nil {
+		return ch.Cancel(tag, false)
+	}
+
+	return nil
+}
+
+func (c *Connection) Publish(exchange, key string, msg amqp.Publishing) error {
+	ch, err := c.Channel()
+	if err != nil {
+		return err
+	}
+
+	err = ch.Publish(exchange, key, false, false, msg)
+	if err != nil {
+		return err
+	}
+
+	PublishedTotal.WithLabelValues(exchange, key).Inc()
+
+	return nil
+}
+
+// ConfirmChannel opens a channel of its own in confirm mode, so confirms
+// stay off the shared channel. The caller closes it.
+func (c *Connection) ConfirmChannel() (*amqp.Channel, error) {
+	c.mu.Lock()
+	conn, closed := c.conn, c.closed
+	c.mu.Unlock()
+
+	if closed {
+		return nil, ErrClosed
+	}
+	if conn == nil {
+		return nil, ErrNotConnected
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	err = ch.Confirm(false)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	return ch, nil
+}
+
+// Channel returns the current channel or ErrNotConnected while reconnecting.
+func (c *Connection) Channel() (*amqp.Channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+	if c.ch == nil {
+		return nil, ErrNotConnected
+	}
+
+	return c.ch, nil
+}
+
+func (c *Connection) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+
+	c.closed = true
+	close(c.done)
+
+	for _, cons := range c.consumers {
+		if cons.forwarders == 0 && !cons.outClosed {
+			close(cons.out)
+			cons.outClosed = true
+		}
+	}
+
+	conn := c.conn
+	c.conn = nil
+	c.ch = nil
+	c.mu.Unlock()
+
+	if conn != nil {
+		return conn.Close()
+	}
+
+	return nil
+}
@@ -2,50 +2,349 @@ package delivery
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
+	"grader/pkg/queue"
+	"grader/pkg/queue/repo"
+	"grader/pkg/queue/service"
+	"grader/pkg/tracing"
 	"net/http"
+	"time"
+)
+
+// Delivery outcomes for the settled counter.
+const (
+	outcomeAck     = "ack"
+	outcomeRequeue = "requeue"
+	outcomeSkip    = "skip"
+	outcomeDrop    = "drop"
 )
 
 type QueueHandler struct {
-	Client *http.Client
-	Logger *zap.Logger
+	Client  *http.Client
+	Logger  *zap.Logger
+	Graders *repo.GraderRepo
+	// GraderAddrs are the graders to register, those that don't answer
+	// are tried again on every load poll.
+	GraderAddrs []string
+	Broker      *queue.Connection
+	// Cancelled holds attempts to skip instead of grading.
+	Cancelled *service.CancelSet
+	// Host names this dispatcher in the status events.
+	Host string
+}
+
+// DiscoverGraders registers the graders of GraderAddrs not registered yet.
+func (h *QueueHandler) DiscoverGraders() {
+	registered := make(map[string]bool)
+	for _, addr := range h.Graders.Addrs() {
+		registered[addr] = true
+	}
+
+	for _, addr := range h.GraderAddrs {
+		if registered[addr] {
+			continue
+		}
+
+		err := h.DiscoverGrader(addr)
+		if err != nil {
+			h.Logger.Error("cant discover grader", zap.String("addr", addr), zap.Error(err))
+		}
+	}
+}
+
+// DiscoverGrader asks the grader at addr what it can run and registers it.
+func (h *QueueHandler) DiscoverGrader(addr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", addr+"/api/v1/grader/capabilities", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected capabilities status %d", resp.StatusCode)
+	}
+
+	caps := queue.GraderCapabilities{}
+	err = json.NewDecoder(resp.Body).Decode(&caps)
+	if err != nil {
+		return err
+	}
+
+	load, err := h.fetchLoad(context.Background(), addr)
+	if err != nil {
+		return err
+	}
+
+	h.Graders.Add(&repo.Grader{
+		Addr:         addr,
+		Capabilities: caps,
+		Load:         *load,
+	})
+
+	h.Logger.Info("Grader registered",
+		zap.String("addr", addr),
+		zap.Strings("languages", caps.Languages),
+		zap.Strings("images", caps.Images),
+		zap.Int("capacity", load.Capacity),
+	)
+
+	return nil
+}
+
+// probeTimeout bounds capability and load requests, the client itself has
+// no timeout as gradings take as long as their limits.
+const probeTimeout = 10 * time.Second
+
+func (h *QueueHandler) fetchLoad(ctx context.Context, addr string) (*queue.GraderLoad, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", addr+"/api/v1/grader/load", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected load status %d", resp.StatusCode)
+	}
+
+	load := &queue.GraderLoad{}
+	err = json.NewDecoder(resp.Body).Decode(load)
+	if err != nil {
+		return nil, err
+	}
+
+	return load, nil
+}
+
+// PollLoad refreshes grader load every interval until ctx is done and
+// sizes the worker pool to the free grader slots. A grader that doesn't
+// answer gets no jobs until it does, one not registered yet is discovered
+// again.
+func (h *QueueHandler) PollLoad(ctx context.Context, interval time.Duration, pool *service.WorkerPool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.DiscoverGraders()
+
+		for _, addr := range h.Graders.Addrs() {
+			load, err := h.fetchLoad(ctx, addr)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				h.Logger.Warn("Grader load unknown, pause it", zap.String("addr", addr), zap.Error(err))
+				load = &queue.GraderLoad{}
+			}
+
+			h.Graders.UpdateLoad(addr, *load)
+		}
+
+		slots := h.Graders.Slots()
+		if slots != pool.Size() {
+			h.Logger.Info("Resize workers", zap.Int("from", pool.Size()), zap.Int("to", slots))
+			pool.Resize(slots)
+		}
+		queue.Workers.Set(float64(slots))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
-func (h *QueueHandler) SolutionWorker(solutions <-chan amqp.Delivery) {
-	for s := range solutions {
-		h.solutionHandler(s)
+// HandleNext handles one delivery, it returns false once the scheduler is
+// closed. Cancelling ctx aborts the in-flight grading and returns its
+// delivery to the queue.
+func (h *QueueHandler) HandleNext(ctx context.Context, scheduler *service.FairScheduler) bool {
+	s, ok := scheduler.Next()
+	if !ok {
+		return false
 	}
+
+	h.solutionHandler(ctx, s)
+
+	return true
 }
 
-func (h *QueueHandler) solutionHandler(s amqp.Delivery) {
+func (h *QueueHandler) solutionHandler(ctx context.Context, s amqp.Delivery) {
 	defer func() {
 		if err := recover(); err != nil {
 			h.Logger.Error("Recovered", zap.Any("panic", err))
 		}
 	}()
 
-	req, err := http.NewRequest("POST", "http://localhost:8080/api/v1/grader/grade", bytes.NewBuffer(s.Body))
+	ctx, span := tracing.Start(tracing.ExtractHeaders(ctx, s.Headers), "solutionHandler",
+		attribute.String("job.routing_key", s.RoutingKey),
+		attribute.Int("job.priority", int(s.Priority)),
+	)
+	defer span.End()
+
+	job := &queue.Job{}
+	err := json.Unmarshal(s.Body, job)
+	if err != nil {
+		tracing.Fail(span, err)
+		h.Logger.Error("Failed to decode job", zap.Error(err))
+		settle(s, outcomeDrop)
+		return
+	}
+
+	span.SetAttributes(
+		attribute.Int("solution.id", job.SolutionID),
+		attribute.Int("solution.attempt", job.Attempt),
+	)
+
+	if h.Cancelled.Has(job.SolutionID, job.Attempt) {
+		h.Logger.Info("Skip cancelled job", zap.Int("solution", job.SolutionID), zap.Int("attempt", job.Attempt))
+		settle(s, outcomeSkip)
+		return
+	}
+
+	g, err := h.Graders.Acquire(ctx, s.RoutingKey)
+	if err != nil {
+		h.Logger.Error("Failed to pick grader", zap.String("routingKey", s.RoutingKey), zap.Error(err))
+		settle(s, outcomeRequeue)
+		return
+	}
+	defer h.Graders.Release(g)
+
+	span.SetAttributes(attribute.String("grader.addr", g.Addr))
+	h.reportDispatched(ctx, job)
+
+	// The grading is synchronous, so the request lives as long as the run
+	// may, whatever the limit of the task.
+	reqCtx, cancel := context.WithTimeout(ctx, job.Capabilities.Limits.DispatchTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, "POST", g.Addr+"/api/v1/grader/grade", bytes.NewBuffer(s.Body))
 	if err != nil {
 		h.Logger.Error("Failed to create HTTP request", zap.Error(err))
-		s.Ack(false)
+		settle(s, outcomeDrop)
 		return
 	}
 	req.Header.Set("Content-Type", "application/json")
+	tracing.InjectHTTP(ctx, req.Header)
 
+	queue.InFlightJobs.Inc()
 	resp, err := h.Client.Do(req)
+	queue.InFlightJobs.Dec()
 	if err != nil {
+		if ctx.Err() != nil {
+			h.Logger.Warn("Grading interrupted by shutdown, requeue", zap.String("routingKey", s.RoutingKey))
+			settle(s, outcomeRequeue)
+			return
+		}
+		if !s.Redelivered {
+			// The grader may have gone away mid-grading, give the job one more run.
+			h.Logger.Warn("Failed to make HTTP request, requeue", zap.Error(err))
+			settle(s, outcomeRequeue)
+			return
+		}
+		tracing.Fail(span, err)
 		h.Logger.Error("Failed to make HTTP request", zap.Error(err))
-		s.Ack(false)
+		settle(s, outcomeDrop)
 		return
 	}
 	resp.Body.Close()
 
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		h.Logger.Warn("Grader unavailable, requeue", zap.String("grader", g.Addr))
+		settle(s, outcomeRequeue)
+		return
+	}
+
 	if resp.StatusCode != http.StatusOK {
+		tracing.Fail(span, fmt.Errorf("unexpected grader status %d", resp.StatusCode))
 		h.Logger.Error("Unexpected response status", zap.Int("status", resp.StatusCode))
-		s.Ack(false)
+		settle(s, outcomeDrop)
 		return
 	}
 
-	s.Ack(false)
+	settle(s, outcomeAck)
+}
+
+// reportDispatched tells the server the job is handed to a grader. Status
+// events are informational, a failure only gets logged.
+func (h *QueueHandler) reportDispatched(ctx context.Context, job *queue.Job) {
+	event, err := json.Marshal(&queue.StatusEvent{
+		Version:    queue.JobVersion,
+		SolutionID: job.SolutionID,
+		Attempt:    job.Attempt,
+		Status:     queue.StatusDispatched,
+		Host:       h.Host,
+	})
+	if err != nil {
+		h.Logger.Error("Failed to marshal status event", zap.Error(err))
+		return
+	}
+
+	headers := amqp.Table{}
+	tracing.InjectHeaders(ctx, headers)
+
+	err = h.Broker.Publish("", queue.ResultQueueName, amqp.Publishing{
+		Headers:      headers,
+		DeliveryMode: amqp.Persistent,
+		ContentType:  "application/json",
+		Body:         event,
+	})
+	if err != nil {
+		h.Logger.Warn("Failed to publish status event", zap.Int("solution", job.SolutionID), zap.Error(err))
+	}
+}
+
+// ConsumeCancels records the cancellations the server broadcasts until
+// deliveries is closed.
+func (h *QueueHandler) ConsumeCancels(deliveries <-chan amqp.Delivery) {
+	for d := range deliveries {
+		c := &queue.Cancel{}
+
+		err := json.Unmarshal(d.Body, c)
+		if err != nil {
+			h.Logger.Error("Failed to decode cancel", zap.Error(err))
+			d.Ack(false)
+			continue
+		}
+
+		h.Cancelled.Add(c.SolutionID, c.Attempt)
+		d.Ack(false)
+	}
+}
+
+// settle acks the delivery, or nacks it back to the queue on requeue, and
+// counts the outcome.
+func settle(d amqp.Delivery, outcome string) {
+	if outcome == outcomeRequeue {
+		d.Nack(false, true)
+	} else {
+		d.Ack(false)
+	}
+
+	queue.SettledTotal.WithLabelValues(outcome).Inc()
+}
+
+// Requeue returns a delivery not handed to a grader to the queue.
+func Requeue(d amqp.Delivery) {
+	settle(d, outcomeRequeue)
 }
@@ -0,0 +1,51 @@
+package queue
+
+// JobVersion is bumped on incompatible changes to Job or Result.
+const JobVersion = 1
+
+// Job is the message published for grading. It references the solution
+// file by content hash, graders fetch the bytes from the server blob store.
+type Job struct {
+	Version      int          `json:"version"`
+	SolutionID   int          `json:"solution_id"`
+	TaskID       int          `json:"task_id"`
+	Attempt      int          `json:"attempt"`
+	FileName     string       `json:"file_name"`
+	ContentHash  string       `json:"content_hash"`
+	SpecVersion  int          `json:"spec_version"`
+	Capabilities Capabilities `json:"capabilities"`
+}
+
+// Progress reports a job reaches on its way to a grader, the server keeps
+// them as solution statuses.
+const (
+	StatusDispatched = "dispatched"
+	StatusRunning    = "running"
+)
+
+// StatusEvent reports job progress. The dispatcher publishes it to the
+// result queue, graders post it to the server.
+type StatusEvent struct {
+	Version    int    `json:"version"`
+	SolutionID int    `json:"solution_id"`
+	Attempt    int    `json:"attempt"`
+	Status     string `json:"status"`
+	Host       string `json:"host"`
+}
+
+// Result is what a grader reports back for a job.
+type Result struct {
+	Version    int    `json:"version"`
+	SolutionID int    `json:"solution_id"`
+	Attempt    int    `json:"attempt"`
+	Pass       bool   `json:"pass"`
+	Text       string `json:"text"`
+	Host       string `json:"host,omitempty"`
+}
+
+// Cancel tells dispatchers to skip the attempt of a solution.
+type Cancel struct {
+	Version    int `json:"version"`
+	SolutionID int `json:"solution_id"`
+	Attempt    int `json:"attempt"`
+}
@@ -0,0 +1,51 @@
+package queue
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	PublishedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grader",
+		Subsystem: "queue",
+		Name:      "published_total",
+		Help:      "Messages published to rabbit.",
+	}, []string{"exchange", "routing_key"})
+
+	ConsumedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grader",
+		Subsystem: "queue",
+		Name:      "consumed_total",
+		Help:      "Deliveries received from rabbit.",
+	}, []string{"queue"})
+
+	// SettledTotal counts how deliveries ended: ack, requeue, skip or drop.
+	SettledTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grader",
+		Subsystem: "queue",
+		Name:      "settled_total",
+		Help:      "Deliveries acked or nacked by outcome.",
+	}, []string{"outcome"})
+
+	BufferedJobs = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "grader",
+		Subsystem: "queue",
+		Name:      "buffered_jobs",
+		Help:      "Prefetched jobs waiting in the fair scheduler.",
+	})
+
+	Workers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "grader",
+		Subsystem: "queue",
+		Name:      "workers",
+		Help:      "Workers sized to the free grader slots.",
+	})
+
+	InFlightJobs = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "grader",
+		Subsystem: "queue",
+		Name:      "in_flight_jobs",
+		Help:      "Jobs sent to graders and not answered yet.",
+	})
+)
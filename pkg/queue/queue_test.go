@@ -0,0 +1,89 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPriority(t *testing.T) {
+	tests := []struct {
+		name       string
+		instructor bool
+		regrade    bool
+		deadline   time.Time
+		want       uint8
+	}{
+		{name: "no deadline", want: PriorityNormal},
+		{name: "deadline far", deadline: time.Now().Add(24 * time.Hour), want: PriorityNormal},
+		{name: "deadline within the hour", deadline: time.Now().Add(30 * time.Minute), want: PriorityDeadline},
+		{name: "deadline passed", deadline: time.Now().Add(-time.Minute), want: PriorityNormal},
+		{name: "regrade near a deadline", regrade: true, deadline: time.Now().Add(time.Minute), want: PriorityRegrade},
+		{name: "instructor", instructor: true, regrade: true, want: PriorityInstructor},
+	}
+
+	for _, tt := range tests {
+		got := Priority(tt.instructor, tt.regrade, tt.deadline)
+		if got != tt.want {
+			t.Errorf("%s: Priority() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCapabilitiesValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		caps Capabilities
+		ok   bool
+	}{
+		{name: "defaults", caps: Capabilities{}, ok: true},
+		{name: "time limit", caps: Capabilities{Limits: Limits{TimeLimit: MaxTimeLimit}}, ok: true},
+		{name: "time limit too long", caps: Capabilities{Limits: Limits{TimeLimit: MaxTimeLimit + 1}}},
+		{name: "negative time limit", caps: Capabilities{Limits: Limits{TimeLimit: -1}}},
+		{name: "standard memory", caps: Capabilities{Limits: Limits{MemoryLimit: StandardMemoryLimit}}, ok: true},
+		{name: "large memory in the standard lane", caps: Capabilities{Limits: Limits{MemoryLimit: LargeMemoryLimit}}},
+		{name: "large memory", caps: Capabilities{LargeMemory: true, Limits: Limits{MemoryLimit: LargeMemoryLimit}}, ok: true},
+		{name: "over large memory", caps: Capabilities{LargeMemory: true, Limits: Limits{MemoryLimit: LargeMemoryLimit + 1}}},
+	}
+
+	for _, tt := range tests {
+		err := tt.caps.Validate()
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+// Limits don't pick the grader, jobs with any limits share a queue.
+func TestRoutingKeyIgnoresLimits(t *testing.T) {
+	c := Capabilities{Language: "go", Image: "img", LargeMemory: true}
+	limited := c
+	limited.Limits = Limits{TimeLimit: 10, MemoryLimit: 1024}
+
+	if c.RoutingKey() != limited.RoutingKey() {
+		t.Errorf("RoutingKey() = %s with limits, %s without", limited.RoutingKey(), c.RoutingKey())
+	}
+	if got, want := c.RoutingKey(), "go.img.nonet.large"; got != want {
+		t.Errorf("RoutingKey() = %s, want %s", got, want)
+	}
+}
+
+func TestLimitsTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits Limits
+		want   time.Duration
+	}{
+		{name: "zero is the default", limits: Limits{}, want: time.Minute},
+		{name: "set", limits: Limits{TimeLimit: 10}, want: 10 * time.Second},
+		{name: "max", limits: Limits{TimeLimit: MaxTimeLimit}, want: 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := tt.limits.Timeout(); got != tt.want {
+			t.Errorf("%s: Timeout() = %v, want %v", tt.name, got, tt.want)
+		}
+		if got := tt.limits.DispatchTimeout(); got <= tt.want {
+			t.Errorf("%s: DispatchTimeout() = %v, want over %v", tt.name, got, tt.want)
+		}
+	}
+}
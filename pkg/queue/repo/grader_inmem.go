@@ -0,0 +1,219 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"grader/pkg/queue"
+	"sync"
+)
+
+var (
+	ErrNoGrader = errors.New("no grader serves routing key")
+	ErrClosed   = errors.New("grader repo closed")
+)
+
+type Grader struct {
+	Addr         string
+	Capabilities queue.GraderCapabilities
+	// Load is the last one the grader reported.
+	Load queue.GraderLoad
+
+	// inFlight counts jobs this dispatcher sent and has no answer for.
+	inFlight int
+	// routes are the routing keys of Capabilities.Routes, set by Add.
+	routes map[string]bool
+}
+
+// limit is how many jobs this dispatcher may run on the grader, its
+// capacity minus what other dispatchers keep busy.
+func (g *Grader) limit() int {
+	others := g.Load.Running - g.inFlight
+	if others < 0 {
+		others = 0
+	}
+
+	limit := g.Load.Capacity - others
+	if limit < 0 {
+		return 0
+	}
+
+	return limit
+}
+
+type GraderRepo struct {
+	mu      *sync.Mutex
+	cond    *sync.Cond
+	graders []*Grader
+	next    map[string]int
+	closed  bool
+	// routes are the ones served by at least one grader.
+	routes  map[string]queue.Capabilities
+	onRoute []func(string)
+}
+
+func NewGraderRepo() *GraderRepo {
+	mu := &sync.Mutex{}
+
+	return &GraderRepo{
+		mu:     mu,
+		cond:   sync.NewCond(mu),
+		next:   make(map[string]int),
+		routes: make(map[string]queue.Capabilities),
+	}
+}
+
+// OnRoute registers a func called with every routing key a grader added
+// later is the first to serve.
+func (repo *GraderRepo) OnRoute(f func(routingKey string)) {
+	repo.mu.Lock()
+	repo.onRoute = append(repo.onRoute, f)
+	repo.mu.Unlock()
+}
+
+func (repo *GraderRepo) Add(g *Grader) {
+	g.routes = make(map[string]bool)
+
+	var added []string
+
+	repo.mu.Lock()
+	for _, c := range g.Capabilities.Routes() {
+		key := c.RoutingKey()
+		g.routes[key] = true
+
+		if _, ok := repo.routes[key]; !ok {
+			repo.routes[key] = c
+			added = append(added, key)
+		}
+	}
+	repo.graders = append(repo.graders, g)
+	onRoute := repo.onRoute
+	repo.cond.Broadcast()
+	repo.mu.Unlock()
+
+	for _, key := range added {
+		for _, f := range onRoute {
+			f(key)
+		}
+	}
+}
+
+// Addrs lists the registered graders.
+func (repo *GraderRepo) Addrs() []string {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	addrs := make([]string, 0, len(repo.graders))
+	for _, g := range repo.graders {
+		addrs = append(addrs, g.Addr)
+	}
+
+	return addrs
+}
+
+func (repo *GraderRepo) UpdateLoad(addr string, load queue.GraderLoad) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, g := range repo.graders {
+		if g.Addr == addr {
+			g.Load = load
+		}
+	}
+
+	repo.cond.Broadcast()
+}
+
+// Slots is how many jobs the graders take from this dispatcher at once.
+func (repo *GraderRepo) Slots() int {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	slots := 0
+	for _, g := range repo.graders {
+		slots += g.limit()
+	}
+
+	return slots
+}
+
+// Routes returns every capability set served by at least one grader,
+// keyed by routing key.
+func (repo *GraderRepo) Routes() map[string]queue.Capabilities {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	routes := make(map[string]queue.Capabilities, len(repo.routes))
+	for key, c := range repo.routes {
+		routes[key] = c
+	}
+
+	return routes
+}
+
+// Acquire waits for a grader able to run jobs with the routing key to have
+// a free slot and takes it, graders are picked round-robin. The slot must
+// be given back with Release.
+func (repo *GraderRepo) Acquire(ctx context.Context, routingKey string) (*Grader, error) {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			repo.mu.Lock()
+			repo.cond.Broadcast()
+			repo.mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for {
+		if repo.closed {
+			return nil, ErrClosed
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		var capable []*Grader
+		for _, g := range repo.graders {
+			if g.routes[routingKey] {
+				capable = append(capable, g)
+			}
+		}
+
+		if len(capable) == 0 {
+			return nil, ErrNoGrader
+		}
+
+		start := repo.next[routingKey]
+		for i := range capable {
+			g := capable[(start+i)%len(capable)]
+			if g.inFlight < g.limit() {
+				repo.next[routingKey] = start + i + 1
+				g.inFlight++
+				return g, nil
+			}
+		}
+
+		repo.cond.Wait()
+	}
+}
+
+func (repo *GraderRepo) Release(g *Grader) {
+	repo.mu.Lock()
+	g.inFlight--
+	repo.cond.Broadcast()
+	repo.mu.Unlock()
+}
+
+// Close makes waiting and later Acquire calls fail with ErrClosed.
+func (repo *GraderRepo) Close() {
+	repo.mu.Lock()
+	repo.closed = true
+	repo.cond.Broadcast()
+	repo.mu.Unlock()
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+type cancelled struct {
+	attempt int
+	at      time.Time
+}
+
+// CancelSet remembers cancelled solution attempts for TTL, long enough for
+// their deliveries to come out of the queue.
+type CancelSet struct {
+	mu        *sync.Mutex
+	cancelled map[int]cancelled
+	ttl       time.Duration
+}
+
+func NewCancelSet(ttl time.Duration) *CancelSet {
+	return &CancelSet{
+		mu:        &sync.Mutex{},
+		cancelled: make(map[int]cancelled),
+		ttl:       ttl,
+	}
+}
+
+func (c *CancelSet) Add(solutionID, attempt int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for id, entry := range c.cancelled {
+		if now.Sub(entry.at) > c.ttl {
+			delete(c.cancelled, id)
+		}
+	}
+
+	c.cancelled[solutionID] = cancelled{
+		attempt: attempt,
+		at:      now,
+	}
+}
+
+func (c *CancelSet) Has(solutionID, attempt int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.cancelled[solutionID]
+
+	return ok && entry.attempt == attempt
+}
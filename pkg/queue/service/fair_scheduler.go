@@ -0,0 +1,127 @@
+package service
+
+import (
+	"github.com/streadway/amqp"
+	"grader/pkg/queue"
+	"sync"
+)
+
+type lane struct {
+	pending map[string][]amqp.Delivery
+	users   []string
+}
+
+// FairScheduler hands out prefetched deliveries by priority lane and
+// round-robin across users within a lane, so one user flooding the queue
+// can't delay everybody else.
+type FairScheduler struct {
+	mu     *sync.Mutex
+	cond   *sync.Cond
+	lanes  [queue.MaxPriority + 1]*lane
+	size   int
+	closed bool
+}
+
+func NewFairScheduler() *FairScheduler {
+	mu := &sync.Mutex{}
+	s := &FairScheduler{
+		mu:   mu,
+		cond: sync.NewCond(mu),
+	}
+
+	for i := range s.lanes {
+		s.lanes[i] = &lane{
+			pending: make(map[string][]amqp.Delivery),
+		}
+	}
+
+	return s
+}
+
+func (s *FairScheduler) Push(d amqp.Delivery) {
+	userID, _ := d.Headers[queue.UserIDHeader].(string)
+
+	priority := d.Priority
+	if priority > queue.MaxPriority {
+		priority = queue.MaxPriority
+	}
+
+	s.mu.Lock()
+	l := s.lanes[priority]
+	if len(l.pending[userID]) == 0 {
+		l.users = append(l.users, userID)
+	}
+	l.pending[userID] = append(l.pending[userID], d)
+	s.size++
+	queue.BufferedJobs.Set(float64(s.size))
+	s.cond.Signal()
+	s.mu.Unlock()
+}
+
+// Next blocks until a delivery is available. It returns false once the
+// scheduler is closed and drained.
+func (s *FairScheduler) Next() (amqp.Delivery, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.size == 0 && !s.closed {
+		s.cond.Wait()
+	}
+
+	if s.size == 0 {
+		return amqp.Delivery{}, false
+	}
+
+	for p := len(s.lanes) - 1; p >= 0; p-- {
+		l := s.lanes[p]
+		if len(l.users) == 0 {
+			continue
+		}
+
+		userID := l.users[0]
+		l.users = l.users[1:]
+
+		deliveries := l.pending[userID]
+		d := deliveries[0]
+
+		if len(deliveries) > 1 {
+			l.pending[userID] = deliveries[1:]
+			l.users = append(l.users, userID)
+		} else {
+			delete(l.pending, userID)
+		}
+
+		s.size--
+		queue.BufferedJobs.Set(float64(s.size))
+
+		return d, true
+	}
+
+	return amqp.Delivery{}, false
+}
+
+func (s *FairScheduler) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// Drain removes and returns every delivery not handed out yet.
+func (s *FairScheduler) Drain() []amqp.Delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deliveries []amqp.Delivery
+	for _, l := range s.lanes {
+		for _, userID := range l.users {
+			deliveries = append(deliveries, l.pending[userID]...)
+			delete(l.pending, userID)
+		}
+		l.users = nil
+	}
+	s.size = 0
+	queue.BufferedJobs.Set(0)
+
+	return deliveries
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"github.com/streadway/amqp"
+	"grader/pkg/queue"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func delivery(id, userID string, priority uint8) amqp.Delivery {
+	return amqp.Delivery{
+		MessageId: id,
+		Priority:  priority,
+		Headers:   amqp.Table{queue.UserIDHeader: userID},
+	}
+}
+
+func TestFairSchedulerOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		push []amqp.Delivery
+		want []string
+	}{
+		{
+			name: "one user keeps order",
+			push: []amqp.Delivery{
+				delivery("a1", "a", queue.PriorityNormal),
+				delivery("a2", "a", queue.PriorityNormal),
+				delivery("a3", "a", queue.PriorityNormal),
+			},
+			want: []string{"a1", "a2", "a3"},
+		},
+		{
+			name: "users take turns",
+			push: []amqp.Delivery{
+				delivery("a1", "a", queue.PriorityNormal),
+				delivery("a2", "a", queue.PriorityNormal),
+				delivery("a3", "a", queue.PriorityNormal),
+				delivery("b1", "b", queue.PriorityNormal),
+				delivery("c1", "c", queue.PriorityNormal),
+				delivery("b2", "b", queue.PriorityNormal),
+			},
+			want: []string{"a1", "b1", "c1", "a2", "b2", "a3"},
+		},
+		{
+			name: "higher lanes first",
+			push: []amqp.Delivery{
+				delivery("regrade", "a", queue.PriorityRegrade),
+				delivery("normal", "a", queue.PriorityNormal),
+				delivery("deadline", "b", queue.PriorityDeadline),
+				delivery("instructor", "c", queue.PriorityInstructor),
+			},
+			want: []string{"instructor", "deadline", "normal", "regrade"},
+		},
+		{
+			name: "priority above the top lane goes to the top lane",
+			push: []amqp.Delivery{
+				delivery("normal", "a", queue.PriorityNormal),
+				delivery("over", "b", queue.MaxPriority+1),
+			},
+			want: []string{"over", "normal"},
+		},
+		{
+			name: "deliveries without a user share one turn",
+			push: []amqp.Delivery{
+				{MessageId: "x1"},
+				{MessageId: "x2"},
+				delivery("a1", "a", 0),
+			},
+			want: []string{"x1", "a1", "x2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewFairScheduler()
+			for _, d := range tt.push {
+				s.Push(d)
+			}
+
+			var got []string
+			for range tt.want {
+				d, ok := s.Next()
+				if !ok {
+					t.Fatalf("Next() ran out after %v", got)
+				}
+				got = append(got, d.MessageId)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFairSchedulerUserRejoins(t *testing.T) {
+	s := NewFairScheduler()
+
+	s.Push(delivery("a1", "a", queue.PriorityNormal))
+	s.Push(delivery("b1", "b", queue.PriorityNormal))
+
+	d, _ := s.Next()
+	if d.MessageId != "a1" {
+		t.Fatalf("first = %s, want a1", d.MessageId)
+	}
+
+	// a drained its queue, a new upload puts it behind b.
+	s.Push(delivery("a2", "a", queue.PriorityNormal))
+
+	var got []string
+	for i := 0; i < 2; i++ {
+		d, _ = s.Next()
+		got = append(got, d.MessageId)
+	}
+
+	want := []string{"b1", "a2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+}
+
+func TestFairSchedulerCloseDrains(t *testing.T) {
+	s := NewFairScheduler()
+
+	s.Push(delivery("a1", "a", queue.PriorityNormal))
+	s.Close()
+
+	d, ok := s.Next()
+	if !ok || d.MessageId != "a1" {
+		t.Fatalf("Next() after Close = %s, %v, want a1, true", d.MessageId, ok)
+	}
+
+	_, ok = s.Next()
+	if ok {
+		t.Fatal("Next() on a closed empty scheduler = true, want false")
+	}
+}
+
+func TestFairSchedulerNextWaits(t *testing.T) {
+	s := NewFairScheduler()
+
+	got := make(chan string)
+	go func() {
+		d, _ := s.Next()
+		got <- d.MessageId
+	}()
+
+	select {
+	case id := <-got:
+		t.Fatalf("Next() returned %s before a push", id)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	s.Push(delivery("a1", "a", queue.PriorityNormal))
+
+	select {
+	case id := <-got:
+		if id != "a1" {
+			t.Errorf("Next() = %s, want a1", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Next() did not wake up on push")
+	}
+}
+
+func TestFairSchedulerDrain(t *testing.T) {
+	s := NewFairScheduler()
+
+	s.Push(delivery("a1", "a", queue.PriorityNormal))
+	s.Push(delivery("a2", "a", queue.PriorityNormal))
+	s.Push(delivery("b1", "b", queue.PriorityInstructor))
+
+	drained := s.Drain()
+	if len(drained) != 3 {
+		t.Fatalf("Drain() = %d deliveries, want 3", len(drained))
+	}
+
+	s.Close()
+	_, ok := s.Next()
+	if ok {
+		t.Error("Next() after Drain and Close = true, want false")
+	}
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"sync"
+)
+
+// WorkerPool runs work in a number of goroutines that can be changed at
+// runtime. Workers above the target exit after their current job.
+type WorkerPool struct {
+	mu      *sync.Mutex
+	wg      *sync.WaitGroup
+	target  int
+	running int
+	// work handles one job, false means there are no more.
+	work func() bool
+}
+
+func NewWorkerPool(work func() bool) *WorkerPool {
+	return &WorkerPool{
+		mu:   &sync.Mutex{},
+		wg:   &sync.WaitGroup{},
+		work: work,
+	}
+}
+
+func (p *WorkerPool) Resize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.target = n
+	for p.running < p.target {
+		p.running++
+		p.wg.Add(1)
+		go p.worker()
+	}
+}
+
+func (p *WorkerPool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.running
+}
+
+// Wait blocks until every worker is gone.
+func (p *WorkerPool) Wait() {
+	p.wg.Wait()
+}
+
+func (p *WorkerPool) worker() {
+	defer p.wg.Done()
+
+	for !p.exit() {
+		if !p.work() {
+			p.mu.Lock()
+			p.running--
+			p.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (p *WorkerPool) exit() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.running > p.target {
+		p.running--
+		return true
+	}
+
+	return false
+}
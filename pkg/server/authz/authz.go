@@ -0,0 +1,172 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"grader/pkg/server/course"
+)
+
+// Roles are bound at three scopes: globally in users.role, per course in
+// enrollments and per task in task_staff. Graders are a role of their own,
+// they sign requests instead of logging in.
+const (
+	RoleStudent   = course.RoleStudent
+	RoleAssistant = course.RoleAssistant
+	RoleTeacher   = course.RoleTeacher
+	RoleAdmin     = "admin"
+	RoleGrader    = "grader"
+)
+
+// Scopes a role was bound at.
+const (
+	ScopeGlobal = "global"
+	ScopeCourse = "course"
+	ScopeTask   = "task"
+)
+
+type Permission string
+
+const (
+	// SubmitSolution is seeing a task, uploading to it and seeing the
+	// solutions the decision covers.
+	SubmitSolution  Permission = "solution:submit"
+	CancelSolution  Permission = "solution:cancel"
+	ReviewSolutions Permission = "solution:review"
+	// GradeSolutions is acting on the work of others: extensions and
+	// cancelling their solutions.
+	GradeSolutions Permission = "solution:grade"
+	RegradeTask    Permission = "task:regrade"
+	ManageTasks    Permission = "task:manage"
+	ManageRoster   Permission = "course:roster"
+	ManageCourses  Permission = "course:manage"
+	ManageJobs     Permission = "jobs:manage"
+	ManageUsers    Permission = "users:manage"
+	ReportResults  Permission = "results:report"
+)
+
+var grants = map[string][]Permission{
+	RoleStudent:   {SubmitSolution, CancelSolution},
+	RoleAssistant: {SubmitSolution, CancelSolution, ReviewSolutions, GradeSolutions},
+	RoleTeacher: {SubmitSolution, CancelSolution, ReviewSolutions, GradeSolutions,
+		RegradeTask, ManageTasks, ManageRoster},
+	RoleAdmin: {SubmitSolution, CancelSolution, ReviewSolutions, GradeSolutions,
+		RegradeTask, ManageTasks, ManageRoster, ManageCourses, ManageJobs, ManageUsers},
+	RoleGrader: {ReportResults},
+}
+
+// Grants reports whether the role has the permission.
+func Grants(role string, p Permission) bool {
+	for _, g := range grants[role] {
+		if g == p {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidGlobalRole reports whether users.role may hold the role. Students
+// get nothing globally, they only see tasks outside courses.
+func ValidGlobalRole(role string) bool {
+	return role == RoleStudent || role == RoleTeacher || role == RoleAdmin
+}
+
+// Subject is who asks: a logged in user, or a grader by its key.
+type Subject struct {
+	UserID   string
+	Username string
+	Grader   string
+}
+
+// Target is what a request acts on, zero ids for what it doesn't name.
+// The policy fills the task of a solution, the course of a task and the
+// owner of a solution.
+type Target struct {
+	CourseID   int
+	TaskID     int
+	SolutionID int
+	OwnerID    string
+}
+
+// Decision is a granted permission and the role that granted it.
+type Decision struct {
+	Subject Subject
+	Target  Target
+	Role    string
+	Scope   string
+	// Members are the users whose work the decision covers besides the
+	// subject: the group of an assistant, nobody for a student. Nil means
+	// everyone.
+	Members map[string]bool
+}
+
+// Covers reports whether the decision lets the subject act on the work
+// of the user.
+func (d *Decision) Covers(userID string) bool {
+	return d.Members == nil || d.Members[userID] || userID == d.Subject.UserID
+}
+
+// All reports whether the decision covers every user.
+func (d *Decision) All() bool {
+	return d.Members == nil
+}
+
+// Users lists the users the decision covers, the subject first, nil for
+// everyone.
+func (d *Decision) Users() []string {
+	if d.All() {
+		return nil
+	}
+
+	users := []string{d.Subject.UserID}
+	for id := range d.Members {
+		if id != d.Subject.UserID {
+			users = append(users, id)
+		}
+	}
+
+	return users
+}
+
+// Staff reports whether the subject teaches where the decision applies.
+func (d *Decision) Staff() bool {
+	return d.Role == RoleAssistant || d.Role == RoleTeacher || d.Role == RoleAdmin
+}
+
+var (
+	ErrForbidden  = errors.New("forbidden")
+	ErrNoDecision = errors.New("no authorization decision in context")
+	ErrBadTarget  = errors.New("bad request target")
+)
+
+type contextKey int
+
+const (
+	subjectKey contextKey = iota
+	decisionKey
+)
+
+// WithSubject marks the request as made by the subject, for callers that
+// don't have a session.
+func WithSubject(ctx context.Context, s Subject) context.Context {
+	return context.WithValue(ctx, subjectKey, s)
+}
+
+func SubjectFromContext(ctx context.Context) (Subject, bool) {
+	s, ok := ctx.Value(subjectKey).(Subject)
+	return s, ok
+}
+
+func WithDecision(ctx context.Context, d *Decision) context.Context {
+	return context.WithValue(ctx, decisionKey, d)
+}
+
+// FromContext returns the decision that let the request through.
+func FromContext(ctx context.Context) (*Decision, error) {
+	d, ok := ctx.Value(decisionKey).(*Decision)
+	if !ok || d == nil {
+		return nil, ErrNoDecision
+	}
+
+	return d, nil
+}
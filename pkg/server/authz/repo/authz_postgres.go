@@ -0,0 +1,147 @@
+package repo
+
+import (
+	"database/sql"
+	"grader/pkg/server/solution"
+	"grader/pkg/server/task"
+	"strconv"
+)
+
+type Pgx struct {
+	DB *sql.DB
+}
+
+type AuthzRepoInterface interface {
+	GlobalRole(string) (string, error)
+	CourseRole(int, string) (string, int, error)
+	TaskRole(int, string) (string, error)
+	TaskCourse(int) (int, error)
+	SolutionTask(int) (int, string, error)
+	GroupMembers(int, int) ([]string, error)
+}
+
+func NewPgxRepo(db *sql.DB) *Pgx {
+	return &Pgx{
+		DB: db,
+	}
+}
+
+func (repo *Pgx) GlobalRole(userID string) (string, error) {
+	var role string
+
+	err := repo.DB.QueryRow(`
+		SELECT role
+		FROM users
+		WHERE id = $1
+	`, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return role, err
+}
+
+// CourseRole returns the role and group of the user in the course, an
+// empty role when they aren't enrolled.
+func (repo *Pgx) CourseRole(courseID int, userID string) (string, int, error) {
+	var role string
+	var groupID sql.NullInt64
+
+	err := repo.DB.QueryRow(`
+		SELECT role, group_id
+		FROM enrollments
+		WHERE course_id = $1 AND user_id = $2
+	`, courseID, userID).Scan(&role, &groupID)
+	if err == sql.ErrNoRows {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	return role, int(groupID.Int64), nil
+}
+
+func (repo *Pgx) TaskRole(taskID int, userID string) (string, error) {
+	var role string
+
+	err := repo.DB.QueryRow(`
+		SELECT role
+		FROM task_staff
+		WHERE task_id = $1 AND user_id = $2
+	`, taskID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return role, err
+}
+
+// TaskCourse returns the course of the task, 0 when it has none.
+func (repo *Pgx) TaskCourse(taskID int) (int, error) {
+	var courseID sql.NullInt64
+
+	err := repo.DB.QueryRow(`
+		SELECT course_id
+		FROM tasks
+		WHERE id = $1
+	`, taskID).Scan(&courseID)
+	if err == sql.ErrNoRows {
+		return 0, task.ErrNoTask
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return int(courseID.Int64), nil
+}
+
+// SolutionTask returns the task and the author of the solution.
+func (repo *Pgx) SolutionTask(solutionID int) (int, string, error) {
+	var taskID int
+	var userID string
+
+	err := repo.DB.QueryRow(`
+		SELECT task_id, user_data->>'id'
+		FROM solutions
+		WHERE id = $1
+	`, solutionID).Scan(&taskID, &userID)
+	if err == sql.ErrNoRows {
+		return 0, "", solution.ErrNoSolution
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	return taskID, userID, nil
+}
+
+func (repo *Pgx) GroupMembers(courseID, groupID int) ([]string, error) {
+	rows, err := repo.DB.Query(`
+		SELECT user_id
+		FROM enrollments
+		WHERE course_id = $1 AND group_id = $2
+	`, courseID, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []string
+	for rows.Next() {
+		var userID int64
+
+		err = rows.Scan(&userID)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, strconv.FormatInt(userID, 10))
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
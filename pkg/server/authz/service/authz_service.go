@@ -0,0 +1,139 @@
+package service
+
+import (
+	"grader/pkg/server/authz"
+	"grader/pkg/server/authz/repo"
+)
+
+type PolicyInterface interface {
+	Authorize(authz.Subject, authz.Permission, authz.Target) (*authz.Decision, error)
+}
+
+type Policy struct {
+	AuthzRepoPQ repo.AuthzRepoInterface
+}
+
+func NewPolicy(pgx repo.AuthzRepoInterface) *Policy {
+	return &Policy{
+		AuthzRepoPQ: pgx,
+	}
+}
+
+// Authorize decides whether the subject has the permission on the target.
+// Global roles apply everywhere, task roles cover every student of the
+// task, course roles cover the course: assistants only their group,
+// students only themselves. Everyone is a student of the tasks outside
+// courses.
+func (h *Policy) Authorize(s authz.Subject, p authz.Permission, t authz.Target) (*authz.Decision, error) {
+	if s.Grader != "" {
+		if !authz.Grants(authz.RoleGrader, p) {
+			return nil, authz.ErrForbidden
+		}
+
+		return &authz.Decision{Subject: s, Target: t, Role: authz.RoleGrader, Scope: authz.ScopeGlobal}, nil
+	}
+
+	t, err := h.resolve(t)
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := h.AuthzRepoPQ.GlobalRole(s.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if role != authz.RoleStudent && authz.Grants(role, p) {
+		return &authz.Decision{Subject: s, Target: t, Role: role, Scope: authz.ScopeGlobal}, nil
+	}
+
+	if t.TaskID != 0 {
+		role, err = h.AuthzRepoPQ.TaskRole(t.TaskID, s.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if authz.Grants(role, p) {
+			return &authz.Decision{Subject: s, Target: t, Role: role, Scope: authz.ScopeTask}, nil
+		}
+	}
+
+	d := &authz.Decision{
+		Subject: s,
+		Target:  t,
+		Role:    authz.RoleStudent,
+		Scope:   authz.ScopeTask,
+		Members: map[string]bool{},
+	}
+	if t.CourseID != 0 {
+		var groupID int
+
+		role, groupID, err = h.AuthzRepoPQ.CourseRole(t.CourseID, s.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+		d.Role = role
+		d.Scope = authz.ScopeCourse
+		d.Members, err = h.members(t.CourseID, groupID, role)
+		if err != nil {
+			return nil, err
+		}
+	} else if t.TaskID == 0 {
+		return nil, authz.ErrForbidden
+	}
+
+	if !authz.Grants(d.Role, p) {
+		return nil, authz.ErrForbidden
+	}
+	if t.OwnerID != "" && !d.Covers(t.OwnerID) {
+		return nil, authz.ErrForbidden
+	}
+
+	return d, nil
+}
+
+// resolve fills the task of a solution, its author and the course of a
+// task.
+func (h *Policy) resolve(t authz.Target) (authz.Target, error) {
+	var err error
+
+	if t.SolutionID != 0 {
+		t.TaskID, t.OwnerID, err = h.AuthzRepoPQ.SolutionTask(t.SolutionID)
+		if err != nil {
+			return t, err
+		}
+	}
+
+	if t.TaskID != 0 {
+		t.CourseID, err = h.AuthzRepoPQ.TaskCourse(t.TaskID)
+		if err != nil {
+			return t, err
+		}
+	}
+
+	return t, nil
+}
+
+// members are the users a course role covers besides the subject.
+func (h *Policy) members(courseID, groupID int, role string) (map[string]bool, error) {
+	switch role {
+	case authz.RoleTeacher:
+		return nil, nil
+	case authz.RoleAssistant:
+		members := map[string]bool{}
+		if groupID == 0 {
+			return members, nil
+		}
+
+		ids, err := h.AuthzRepoPQ.GroupMembers(courseID, groupID)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			members[id] = true
+		}
+
+		return members, nil
+	default:
+		return map[string]bool{}, nil
+	}
+}
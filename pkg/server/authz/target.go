@@ -0,0 +1,79 @@
+package authz
+
+import (
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+)
+
+// TargetFunc reads the target of a request from its route or form.
+type TargetFunc func(*http.Request) (Target, error)
+
+// Global targets nothing in particular, only global roles apply.
+func Global(*http.Request) (Target, error) {
+	return Target{}, nil
+}
+
+func TaskParam(name string) TargetFunc {
+	return func(r *http.Request) (Target, error) {
+		id, err := atoi(chi.URLParam(r, name))
+		return Target{TaskID: id}, err
+	}
+}
+
+func TaskForm(name string) TargetFunc {
+	return func(r *http.Request) (Target, error) {
+		id, err := atoi(r.FormValue(name))
+		return Target{TaskID: id}, err
+	}
+}
+
+func SolutionForm(name string) TargetFunc {
+	return func(r *http.Request) (Target, error) {
+		id, err := atoi(r.FormValue(name))
+		return Target{SolutionID: id}, err
+	}
+}
+
+func CourseParam(name string) TargetFunc {
+	return func(r *http.Request) (Target, error) {
+		id, err := atoi(chi.URLParam(r, name))
+		return Target{CourseID: id}, err
+	}
+}
+
+// CourseForm targets the course of a form or query value, an empty one
+// targets tasks outside courses, so global roles.
+func CourseForm(name string) TargetFunc {
+	return func(r *http.Request) (Target, error) {
+		v := r.FormValue(name)
+		if v == "" || v == "0" {
+			return Target{}, nil
+		}
+
+		id, err := atoi(v)
+		return Target{CourseID: id}, err
+	}
+}
+
+// TaskOrCourseForm targets the task of a form value if it names one,
+// otherwise the course of another, for forms shared by new and existing
+// tasks.
+func TaskOrCourseForm(task, course string) TargetFunc {
+	return func(r *http.Request) (Target, error) {
+		if r.FormValue(task) != "" {
+			return TaskForm(task)(r)
+		}
+
+		return CourseForm(course)(r)
+	}
+}
+
+func atoi(v string) (int, error) {
+	id, err := strconv.Atoi(v)
+	if err != nil || id <= 0 {
+		return 0, ErrBadTarget
+	}
+
+	return id, nil
+}
@@ -0,0 +1,31 @@
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrNoBlob  = errors.New("blob not found")
+	ErrBadHash = errors.New("bad blob hash: must be 64 lowercase hex digits")
+)
+
+// Hash is the content address blobs are stored under.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidHash reports whether hash is one Hash makes, hashes from requests
+// must be checked before they name a file.
+func ValidHash(hash string) bool {
+	if len(hash) != 2*sha256.Size || strings.ToLower(hash) != hash {
+		return false
+	}
+
+	_, err := hex.DecodeString(hash)
+
+	return err == nil
+}
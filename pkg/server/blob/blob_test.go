@@ -0,0 +1,30 @@
+package blob
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidHash(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+		want bool
+	}{
+		{name: "sha256", hash: strings.Repeat("0123456789abcdef", 4), want: true},
+		{name: "empty", hash: ""},
+		{name: "short", hash: strings.Repeat("ab", 31)},
+		{name: "long", hash: strings.Repeat("ab", 33)},
+		{name: "upper case", hash: strings.Repeat("0123456789ABCDEF", 4)},
+		{name: "not hex", hash: strings.Repeat("g", 64)},
+		{name: "path", hash: "../../../../etc/passwd" + strings.Repeat("a", 42)},
+		{name: "slashes", hash: "ab/" + strings.Repeat("c", 61)},
+	}
+
+	for _, tt := range tests {
+		got := ValidHash(tt.hash)
+		if got != tt.want {
+			t.Errorf("%s: ValidHash(%q) = %v, want %v", tt.name, tt.hash, got, tt.want)
+		}
+	}
+}
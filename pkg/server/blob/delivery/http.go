@@ -0,0 +1,37 @@
+package delivery
+
+import (
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+	"grader/pkg/server/blob"
+	"grader/pkg/server/blob/repo"
+	"grader/pkg/utils"
+	"net/http"
+)
+
+type BlobHandler struct {
+	BlobRepo repo.BlobRepoInterface
+}
+
+func (h *BlobHandler) Blob(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	hash := chi.URLParam(r, "hash")
+
+	data, err := h.BlobRepo.Get(hash)
+	if err == blob.ErrBadHash {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err == blob.ErrNoBlob {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("Error get blob", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
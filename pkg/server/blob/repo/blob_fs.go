@@ -0,0 +1,95 @@
+package repo
+
+import (
+	"errors"
+	"grader/pkg/server/blob"
+	"os"
+	"path/filepath"
+)
+
+type BlobRepoInterface interface {
+	Put([]byte) (string, error)
+	Get(string) ([]byte, error)
+}
+
+type FS struct {
+	Dir string
+}
+
+func NewFSRepo(dir string) (*FS, error) {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FS{
+		Dir: dir,
+	}, nil
+}
+
+func (repo *FS) path(hash string) (string, error) {
+	if !blob.ValidHash(hash) {
+		return "", blob.ErrBadHash
+	}
+
+	return filepath.Join(repo.Dir, hash[:2], hash), nil
+}
+
+func (repo *FS) Put(data []byte) (string, error) {
+	hash := blob.Hash(data)
+
+	p, err := repo.path(hash)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = os.Stat(p); err == nil {
+		return hash, nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(p), 0750)
+	if err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), "upload")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return "", err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return "", err
+	}
+
+	err = os.Rename(tmp.Name(), p)
+	if err != nil {
+		return "", err
+	}
+
+	return hash, nil
+}
+
+func (repo *FS) Get(hash string) ([]byte, error) {
+	p, err := repo.path(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, blob.ErrNoBlob
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
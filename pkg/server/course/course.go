@@ -0,0 +1,57 @@
+package course
+
+import (
+	"errors"
+	"time"
+)
+
+type Course struct {
+	ID          int
+	Name        string
+	Description string
+	// LateDays is the late-day budget every student of the course has.
+	LateDays  int
+	CreatedAt time.Time
+}
+
+// Group is a study group within a course.
+type Group struct {
+	ID       int
+	CourseID int
+	Name     string
+}
+
+// Roles a user has in a course they are enrolled in.
+const (
+	RoleStudent   = "student"
+	RoleAssistant = "assistant"
+	RoleTeacher   = "teacher"
+)
+
+func ValidRole(role string) bool {
+	return role == RoleStudent || role == RoleAssistant || role == RoleTeacher
+}
+
+// Staff reports whether the role sees the work of every student.
+func Staff(role string) bool {
+	return role == RoleAssistant || role == RoleTeacher
+}
+
+// Enrollment puts a user into a course with a role, and into one of its
+// groups when GroupID is set.
+type Enrollment struct {
+	CourseID  int
+	UserID    string
+	Username  string
+	GroupID   int
+	GroupName string
+	Role      string
+	CreatedAt time.Time
+}
+
+var (
+	ErrNoCourse      = errors.New("course not found")
+	ErrNotEnrolled   = errors.New("user is not enrolled in the course")
+	ErrBadEnrollment = errors.New("bad enrollment: role must be student, assistant or teacher and the group of the course")
+	ErrBadCourse     = errors.New("bad course: a name and a non-negative late-day budget are required")
+)
@@ -0,0 +1,239 @@
+package delivery
+
+import (
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+	"grader/pkg/server/course"
+	"grader/pkg/server/course/service"
+	"grader/pkg/server/session"
+	"grader/pkg/server/user"
+	userService "grader/pkg/server/user/service"
+	"grader/pkg/utils"
+	"html/template"
+	"net/http"
+	"strconv"
+)
+
+type CourseHandler struct {
+	Tmpl          *template.Template
+	CourseService service.CourseServiceInterface
+	UserService   userService.UserServiceInterface
+}
+
+type CoursesData struct {
+	User    *user.Claims
+	Courses []*course.Course
+}
+
+type CourseData struct {
+	User   *user.Claims
+	Course *course.Course
+	Groups []*course.Group
+	Roster []*course.Enrollment
+	Roles  []string
+}
+
+// Courses and the handlers below are guarded by the authorization
+// middleware: creating courses needs a global role, managing one a role
+// in it.
+func (h *CourseHandler) Courses(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	sess, err := session.SessionFromContext(ctx)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get session from context", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	courses, err := h.CourseService.GetCourseList()
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get course list", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.Tmpl.ExecuteTemplate(w, "courses.html", &CoursesData{
+		User:    sess.User,
+		Courses: courses,
+	})
+	if err != nil {
+		utils.GetLogger(ctx).Error("error execute template", zap.Error(err))
+		http.Error(w, `Template error`, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *CourseHandler) Course(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	courseID := chi.URLParam(r, "id")
+
+	sess, err := session.SessionFromContext(ctx)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get session from context", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	c, err := h.CourseService.GetCourseByID(courseID)
+	if err == course.ErrNoCourse {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get course", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	groups, err := h.CourseService.GetGroups(c.ID)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get groups", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	roster, err := h.CourseService.GetRoster(c.ID)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get roster", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.Tmpl.ExecuteTemplate(w, "course.html", &CourseData{
+		User:   sess.User,
+		Course: c,
+		Groups: groups,
+		Roster: roster,
+		Roles:  []string{course.RoleStudent, course.RoleAssistant, course.RoleTeacher},
+	})
+	if err != nil {
+		utils.GetLogger(ctx).Error("error execute template", zap.Error(err))
+		http.Error(w, `Template error`, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *CourseHandler) CourseAdd(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	lateDays := 0
+	if v := r.FormValue("late_days"); v != "" {
+		lateDays, err = strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, course.ErrBadCourse.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = h.CourseService.CreateCourse(r.FormValue("name"), r.FormValue("description"), lateDays)
+	if err == course.ErrBadCourse {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error create course", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/tasks/admin/courses", http.StatusFound)
+}
+
+func (h *CourseHandler) GroupAdd(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	courseID, err := strconv.Atoi(r.FormValue("course_id"))
+	if err != nil {
+		http.Error(w, "Invalid course id", http.StatusBadRequest)
+		return
+	}
+
+	err = h.CourseService.CreateGroup(courseID, r.FormValue("name"))
+	if err == course.ErrBadCourse {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error create group", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	url := fmt.Sprintf("/tasks/admin/courses/%d", courseID)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// Enroll puts a user into the course, or changes the role and group of one
+// already there.
+func (h *CourseHandler) Enroll(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	courseID, err := strconv.Atoi(r.FormValue("course_id"))
+	if err != nil {
+		http.Error(w, "Invalid course id", http.StatusBadRequest)
+		return
+	}
+
+	groupID := 0
+	if v := r.FormValue("group_id"); v != "" {
+		groupID, err = strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, course.ErrBadEnrollment.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	student, err := h.UserService.UserByName(r.FormValue("username"))
+	if err == user.ErrNoUser {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get user by name", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.CourseService.Enroll(&course.Enrollment{
+		CourseID: courseID,
+		UserID:   student.ID,
+		GroupID:  groupID,
+		Role:     r.FormValue("role"),
+	})
+	if err == course.ErrBadEnrollment {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error enroll user", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	url := fmt.Sprintf("/tasks/admin/courses/%d", courseID)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func (h *CourseHandler) Unenroll(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	courseID, err := strconv.Atoi(r.FormValue("course_id"))
+	if err != nil {
+		http.Error(w, "Invalid course id", http.StatusBadRequest)
+		return
+	}
+
+	err = h.CourseService.Unenroll(courseID, r.FormValue("user_id"))
+	if err != nil {
+		utils.GetLogger(ctx).Error("error unenroll user", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	url := fmt.Sprintf("/tasks/admin/courses/%d", courseID)
+	http.Redirect(w, r, url, http.StatusFound)
+}
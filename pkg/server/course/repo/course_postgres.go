@@ -0,0 +1,271 @@
+package repo
+
+import (
+	"database/sql"
+	"grader/pkg/server/course"
+	"strconv"
+)
+
+type Pgx struct {
+	DB *sql.DB
+}
+
+type CourseRepoInterface interface {
+	Add(*course.Course) error
+	Get(int) (*course.Course, error)
+	List() ([]*course.Course, error)
+	ListByUser(string) ([]*course.Course, error)
+	AddGroup(*course.Group) error
+	GetGroup(int) (*course.Group, error)
+	ListGroups(int) ([]*course.Group, error)
+	Enroll(*course.Enrollment) error
+	Unenroll(int, string) error
+	GetEnrollment(int, string) (*course.Enrollment, error)
+	ListEnrollments(int) ([]*course.Enrollment, error)
+}
+
+func NewPgxRepo(db *sql.DB) *Pgx {
+	return &Pgx{
+		DB: db,
+	}
+}
+
+func (repo *Pgx) Add(c *course.Course) error {
+	return repo.DB.QueryRow(`
+		INSERT INTO courses (name, description, late_days)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at
+	`, c.Name, c.Description, c.LateDays).Scan(&c.ID, &c.CreatedAt)
+}
+
+func (repo *Pgx) Get(courseID int) (*course.Course, error) {
+	c := &course.Course{}
+
+	err := repo.DB.QueryRow(`
+		SELECT id, name, description, late_days, created_at
+		FROM courses
+		WHERE id = $1
+	`, courseID).Scan(&c.ID, &c.Name, &c.Description, &c.LateDays, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, course.ErrNoCourse
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (repo *Pgx) List() ([]*course.Course, error) {
+	return repo.listCourses(`
+		SELECT id, name, description, late_days, created_at
+		FROM courses
+		ORDER BY name
+	`)
+}
+
+// ListByUser returns the courses the user is enrolled in.
+func (repo *Pgx) ListByUser(userID string) ([]*course.Course, error) {
+	return repo.listCourses(`
+		SELECT c.id, c.name, c.description, c.late_days, c.created_at
+		FROM courses c
+		JOIN enrollments e ON e.course_id = c.id
+		WHERE e.user_id = $1
+		ORDER BY c.name
+	`, userID)
+}
+
+func (repo *Pgx) listCourses(query string, args ...interface{}) ([]*course.Course, error) {
+	rows, err := repo.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var courses []*course.Course
+	for rows.Next() {
+		c := &course.Course{}
+
+		err = rows.Scan(&c.ID, &c.Name, &c.Description, &c.LateDays, &c.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		courses = append(courses, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return courses, nil
+}
+
+func (repo *Pgx) AddGroup(g *course.Group) error {
+	return repo.DB.QueryRow(`
+		INSERT INTO course_groups (course_id, name)
+		VALUES ($1, $2)
+		RETURNING id
+	`, g.CourseID, g.Name).Scan(&g.ID)
+}
+
+func (repo *Pgx) GetGroup(groupID int) (*course.Group, error) {
+	g := &course.Group{}
+
+	err := repo.DB.QueryRow(`
+		SELECT id, course_id, name
+		FROM course_groups
+		WHERE id = $1
+	`, groupID).Scan(&g.ID, &g.CourseID, &g.Name)
+	if err == sql.ErrNoRows {
+		return nil, course.ErrBadEnrollment
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+func (repo *Pgx) ListGroups(courseID int) ([]*course.Group, error) {
+	rows, err := repo.DB.Query(`
+		SELECT id, course_id, name
+		FROM course_groups
+		WHERE course_id = $1
+		ORDER BY name
+	`, courseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []*course.Group
+	for rows.Next() {
+		g := &course.Group{}
+
+		err = rows.Scan(&g.ID, &g.CourseID, &g.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		groups = append(groups, g)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// Enroll adds the user to the course or changes their role and group.
+func (repo *Pgx) Enroll(e *course.Enrollment) error {
+	_, err := repo.DB.Exec(`
+		INSERT INTO enrollments (course_id, user_id, group_id, role)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (course_id, user_id) DO UPDATE
+		SET group_id = EXCLUDED.group_id, role = EXCLUDED.role
+	`, e.CourseID, e.UserID, nullID(e.GroupID), e.Role)
+
+	return err
+}
+
+func (repo *Pgx) Unenroll(courseID int, userID string) error {
+	_, err := repo.DB.Exec(`
+		DELETE FROM enrollments
+		WHERE course_id = $1 AND user_id = $2
+	`, courseID, userID)
+
+	return err
+}
+
+func (repo *Pgx) GetEnrollment(courseID int, userID string) (*course.Enrollment, error) {
+	row := repo.DB.QueryRow(`
+		SELECT `+enrollmentColumns+`
+		FROM enrollments e
+		JOIN users u ON u.id = e.user_id
+		LEFT JOIN course_groups g ON g.id = e.group_id
+		WHERE e.course_id = $1 AND e.user_id = $2
+	`, courseID, userID)
+
+	e, err := scanEnrollment(row)
+	if err == sql.ErrNoRows {
+		return nil, course.ErrNotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (repo *Pgx) ListEnrollments(courseID int) ([]*course.Enrollment, error) {
+	rows, err := repo.DB.Query(`
+		SELECT `+enrollmentColumns+`
+		FROM enrollments e
+		JOIN users u ON u.id = e.user_id
+		LEFT JOIN course_groups g ON g.id = e.group_id
+		WHERE e.course_id = $1
+		ORDER BY CASE e.role WHEN 'teacher' THEN 0 WHEN 'assistant' THEN 1 ELSE 2 END, g.name NULLS FIRST, u.username
+	`, courseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var enrollments []*course.Enrollment
+	for rows.Next() {
+		e, err := scanEnrollment(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		enrollments = append(enrollments, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return enrollments, nil
+}
+
+const enrollmentColumns = `e.course_id, e.user_id, u.username, e.group_id, g.name, e.role, e.created_at`
+
+type scanner interface {
+	Scan(...interface{}) error
+}
+
+func scanEnrollment(row scanner) (*course.Enrollment, error) {
+	e := &course.Enrollment{}
+	var userID int64
+	var groupID sql.NullInt64
+	var groupName sql.NullString
+
+	err := row.Scan(
+		&e.CourseID,
+		&userID,
+		&e.Username,
+		&groupID,
+		&groupName,
+		&e.Role,
+		&e.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	e.UserID = strconv.FormatInt(userID, 10)
+	e.GroupID = int(groupID.Int64)
+	e.GroupName = groupName.String
+
+	return e, nil
+}
+
+// nullID stores the zero id, meaning none, as NULL.
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{
+		Int64: int64(id),
+		Valid: id != 0,
+	}
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"grader/pkg/server/course"
+	"grader/pkg/server/course/repo"
+	"strconv"
+	"strings"
+)
+
+type CourseServiceInterface interface {
+	CreateCourse(string, string, int) error
+	GetCourseList() ([]*course.Course, error)
+	GetCourseByID(string) (*course.Course, error)
+	GetCoursesByUser(string) ([]*course.Course, error)
+	CreateGroup(int, string) error
+	GetGroups(int) ([]*course.Group, error)
+	Enroll(*course.Enrollment) error
+	Unenroll(int, string) error
+	GetRoster(int) ([]*course.Enrollment, error)
+	Role(string, int) (string, error)
+}
+
+type CourseService struct {
+	CourseRepoPQ repo.CourseRepoInterface
+}
+
+func NewCourseService(pgx repo.CourseRepoInterface) *CourseService {
+	return &CourseService{
+		CourseRepoPQ: pgx,
+	}
+}
+
+func (h *CourseService) CreateCourse(name, description string, lateDays int) error {
+	name = strings.TrimSpace(name)
+	if name == "" || lateDays < 0 {
+		return course.ErrBadCourse
+	}
+
+	return h.CourseRepoPQ.Add(&course.Course{
+		Name:        name,
+		Description: description,
+		LateDays:    lateDays,
+	})
+}
+
+func (h *CourseService) GetCourseList() ([]*course.Course, error) {
+	return h.CourseRepoPQ.List()
+}
+
+func (h *CourseService) GetCourseByID(courseID string) (*course.Course, error) {
+	id, err := strconv.Atoi(courseID)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.CourseRepoPQ.Get(id)
+}
+
+func (h *CourseService) GetCoursesByUser(userID string) ([]*course.Course, error) {
+	return h.CourseRepoPQ.ListByUser(userID)
+}
+
+func (h *CourseService) CreateGroup(courseID int, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return course.ErrBadCourse
+	}
+
+	return h.CourseRepoPQ.AddGroup(&course.Group{
+		CourseID: courseID,
+		Name:     name,
+	})
+}
+
+func (h *CourseService) GetGroups(courseID int) ([]*course.Group, error) {
+	return h.CourseRepoPQ.ListGroups(courseID)
+}
+
+func (h *CourseService) Enroll(e *course.Enrollment) error {
+	if !course.ValidRole(e.Role) {
+		return course.ErrBadEnrollment
+	}
+
+	if e.GroupID != 0 {
+		g, err := h.CourseRepoPQ.GetGroup(e.GroupID)
+		if err != nil {
+			return err
+		}
+		if g.CourseID != e.CourseID {
+			return course.ErrBadEnrollment
+		}
+	}
+
+	return h.CourseRepoPQ.Enroll(e)
+}
+
+func (h *CourseService) Unenroll(courseID int, userID string) error {
+	return h.CourseRepoPQ.Unenroll(courseID, userID)
+}
+
+func (h *CourseService) GetRoster(courseID int) ([]*course.Enrollment, error) {
+	return h.CourseRepoPQ.ListEnrollments(courseID)
+}
+
+// Role returns the role of the user in the course, ErrNotEnrolled if they
+// have none.
+func (h *CourseService) Role(userID string, courseID int) (string, error) {
+	e, err := h.CourseRepoPQ.GetEnrollment(courseID, userID)
+	if err != nil {
+		return "", err
+	}
+
+	return e.Role, nil
+}
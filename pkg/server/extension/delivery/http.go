@@ -0,0 +1,88 @@
+package delivery
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"grader/pkg/server/authz"
+	"grader/pkg/server/extension"
+	"grader/pkg/server/extension/service"
+	"grader/pkg/server/task"
+	"grader/pkg/server/user"
+	userService "grader/pkg/server/user/service"
+	"grader/pkg/utils"
+	"net/http"
+	"strconv"
+)
+
+type ExtensionHandler struct {
+	ExtensionService service.ExtensionServiceInterface
+	UserService      userService.UserServiceInterface
+}
+
+// Grant gives a student an extension on a task, staff may grant them to
+// the students they grade.
+func (h *ExtensionHandler) Grant(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	taskID := r.FormValue("id")
+
+	d, err := authz.FromContext(ctx)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get authorization from context", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	tID, err := strconv.Atoi(taskID)
+	if err != nil {
+		http.Error(w, "Invalid task id", http.StatusBadRequest)
+		return
+	}
+
+	student, err := h.UserService.UserByName(r.FormValue("username"))
+	if err == user.ErrNoUser {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get user by name", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if !d.Covers(student.ID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	soft, err := task.ParseDeadline(r.FormValue("soft_deadline"))
+	if err != nil {
+		http.Error(w, extension.ErrBadExtension.Error(), http.StatusBadRequest)
+		return
+	}
+	hard, err := task.ParseDeadline(r.FormValue("hard_deadline"))
+	if err != nil {
+		http.Error(w, extension.ErrBadExtension.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.ExtensionService.Grant(&extension.Extension{
+		UserID:       student.ID,
+		TaskID:       tID,
+		SoftDeadline: soft,
+		HardDeadline: hard,
+		Reason:       r.FormValue("reason"),
+		GrantedBy:    d.Subject.Username,
+	})
+	if err == extension.ErrBadExtension {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error grant extension", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	url := fmt.Sprintf("/tasks/admin/task/%d/solutions", tID)
+	http.Redirect(w, r, url, http.StatusFound)
+}
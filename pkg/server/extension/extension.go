@@ -0,0 +1,80 @@
+package extension
+
+import (
+	"errors"
+	"grader/pkg/server/task"
+	"time"
+)
+
+// Extension moves the deadlines of one task for one user, admins grant
+// them for documented excuses.
+type Extension struct {
+	ID       int
+	UserID   string
+	Username string
+	TaskID   int
+	// SoftDeadline replaces the task one. HardDeadline, when set, replaces
+	// the task one too; otherwise uploads stay open at least until
+	// SoftDeadline.
+	SoftDeadline time.Time
+	HardDeadline time.Time
+	Reason       string
+	// GrantedBy is the username of the admin.
+	GrantedBy string
+	CreatedAt time.Time
+}
+
+// Apply returns the task policy with the deadlines of the extension.
+func (e *Extension) Apply(p task.Policy) task.Policy {
+	p.SoftDeadline = e.SoftDeadline
+
+	switch {
+	case !e.HardDeadline.IsZero():
+		p.HardDeadline = e.HardDeadline
+	case !p.HardDeadline.IsZero() && p.HardDeadline.Before(e.SoftDeadline):
+		p.HardDeadline = e.SoftDeadline
+	}
+
+	return p
+}
+
+// Admission is how an upload fits the deadlines of its user.
+type Admission struct {
+	Late    time.Duration
+	Penalty int
+	// LateDays is what the user spends of their budget on the task, it
+	// covers Late so there is no penalty.
+	LateDays int
+	// Budget and CourseID are set while LateDays are still to be spent,
+	// with the upload. If they don't fit the budget by then, Fallback
+	// admits the upload instead, nil means it is rejected.
+	Budget   int
+	CourseID int
+	Fallback *Admission
+	// Deadline is the next deadline of the user for the task, zero when
+	// none is left.
+	Deadline time.Time
+}
+
+// Spends reports whether storing the upload has to spend LateDays.
+func (a *Admission) Spends() bool {
+	return a.LateDays > 0 && a.Budget > 0
+}
+
+// Settle is how the upload is admitted once it is known whether its late
+// days fit the budget.
+func (a *Admission) Settle(fits bool) (*Admission, error) {
+	if fits || !a.Spends() {
+		return a, nil
+	}
+	if a.Fallback == nil {
+		return nil, task.ErrPastDeadline
+	}
+
+	return a.Fallback, nil
+}
+
+var (
+	ErrNoExtension  = errors.New("extension not found")
+	ErrBadExtension = errors.New("bad extension: a reason and a soft deadline before the hard one are required")
+)
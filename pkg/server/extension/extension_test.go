@@ -0,0 +1,93 @@
+package extension
+
+import (
+	"grader/pkg/server/task"
+	"testing"
+	"time"
+)
+
+func TestExtensionApply(t *testing.T) {
+	soft := time.Date(2024, 3, 1, 23, 59, 0, 0, time.UTC)
+	hard := soft.Add(7 * 24 * time.Hour)
+	policy := task.Policy{SoftDeadline: soft, HardDeadline: hard, LatePolicy: task.LateFlag}
+
+	tests := []struct {
+		name     string
+		ext      Extension
+		policy   task.Policy
+		wantSoft time.Time
+		wantHard time.Time
+	}{
+		{
+			name:     "soft only, before the task hard deadline",
+			ext:      Extension{SoftDeadline: soft.Add(24 * time.Hour)},
+			policy:   policy,
+			wantSoft: soft.Add(24 * time.Hour),
+			wantHard: hard,
+		},
+		{
+			name:     "soft only, past the task hard deadline",
+			ext:      Extension{SoftDeadline: hard.Add(24 * time.Hour)},
+			policy:   policy,
+			wantSoft: hard.Add(24 * time.Hour),
+			wantHard: hard.Add(24 * time.Hour),
+		},
+		{
+			name:     "both",
+			ext:      Extension{SoftDeadline: soft.Add(24 * time.Hour), HardDeadline: hard.Add(24 * time.Hour)},
+			policy:   policy,
+			wantSoft: soft.Add(24 * time.Hour),
+			wantHard: hard.Add(24 * time.Hour),
+		},
+		{
+			name:     "task without a hard deadline",
+			ext:      Extension{SoftDeadline: soft.Add(24 * time.Hour)},
+			policy:   task.Policy{SoftDeadline: soft},
+			wantSoft: soft.Add(24 * time.Hour),
+		},
+	}
+
+	for _, tt := range tests {
+		got := tt.ext.Apply(tt.policy)
+		if !got.SoftDeadline.Equal(tt.wantSoft) || !got.HardDeadline.Equal(tt.wantHard) {
+			t.Errorf("%s: Apply() = %v, %v, want %v, %v", tt.name,
+				got.SoftDeadline, got.HardDeadline, tt.wantSoft, tt.wantHard)
+		}
+	}
+}
+
+func TestAdmissionSettle(t *testing.T) {
+	penalised := &Admission{Late: time.Hour, Penalty: 10}
+	spending := &Admission{Late: time.Hour, LateDays: 1, Budget: 3, CourseID: 1, Fallback: penalised}
+	rejecting := &Admission{Late: time.Hour, LateDays: 1, Budget: 3, CourseID: 1}
+
+	tests := []struct {
+		name    string
+		a       *Admission
+		fits    bool
+		want    *Admission
+		wantErr error
+	}{
+		{name: "on time", a: &Admission{}, want: nil},
+		{name: "no budget to spend", a: penalised, want: penalised},
+		{name: "fits", a: spending, fits: true, want: spending},
+		{name: "doesn't fit, penalty instead", a: spending, want: penalised},
+		{name: "doesn't fit, rejected", a: rejecting, wantErr: task.ErrPastDeadline},
+	}
+
+	for _, tt := range tests {
+		want := tt.want
+		if want == nil && tt.wantErr == nil {
+			want = tt.a
+		}
+
+		got, err := tt.a.Settle(tt.fits)
+		if err != tt.wantErr {
+			t.Errorf("%s: Settle() error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s: Settle() = %+v, want %+v", tt.name, got, want)
+		}
+	}
+}
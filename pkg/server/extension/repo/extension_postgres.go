@@ -0,0 +1,184 @@
+package repo
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+	"grader/pkg/server/extension"
+	"strconv"
+	"time"
+)
+
+type Pgx struct {
+	DB *sql.DB
+}
+
+type ExtensionRepoInterface interface {
+	Grant(*extension.Extension) error
+	Get(string, int) (*extension.Extension, error)
+	ListByTaskUsers(int, []string) ([]*extension.Extension, error)
+	LateDaysSpent(string, int) (int, error)
+}
+
+func NewPgxRepo(db *sql.DB) *Pgx {
+	return &Pgx{
+		DB: db,
+	}
+}
+
+// Grant stores the extension, replacing the one the user had for the task.
+func (repo *Pgx) Grant(e *extension.Extension) error {
+	_, err := repo.DB.Exec(`
+		INSERT INTO extensions (user_id, task_id, soft_deadline, hard_deadline, reason, granted_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, task_id) DO UPDATE
+		SET soft_deadline = EXCLUDED.soft_deadline, hard_deadline = EXCLUDED.hard_deadline,
+		    reason = EXCLUDED.reason, granted_by = EXCLUDED.granted_by, created_at = NOW()
+	`, e.UserID, e.TaskID, e.SoftDeadline, nullTime(e.HardDeadline), e.Reason, e.GrantedBy)
+
+	return err
+}
+
+func (repo *Pgx) Get(userID string, taskID int) (*extension.Extension, error) {
+	row := repo.DB.QueryRow(`
+		SELECT `+extensionColumns+`
+		FROM extensions e
+		JOIN users u ON u.id = e.user_id
+		WHERE e.user_id = $1 AND e.task_id = $2
+	`, userID, taskID)
+
+	e, err := scanExtension(row)
+	if err == sql.ErrNoRows {
+		return nil, extension.ErrNoExtension
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// ListByTaskUsers returns the extensions of the users for the task.
+func (repo *Pgx) ListByTaskUsers(taskID int, userIDs []string) ([]*extension.Extension, error) {
+	rows, err := repo.DB.Query(`
+		SELECT `+extensionColumns+`
+		FROM extensions e
+		JOIN users u ON u.id = e.user_id
+		WHERE e.task_id = $1 AND e.user_id = ANY($2)
+		ORDER BY u.username
+	`, taskID, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var extensions []*extension.Extension
+	for rows.Next() {
+		e, err := scanExtension(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		extensions = append(extensions, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return extensions, nil
+}
+
+// LateDaysSpent sums the late days the user spent on tasks of the course,
+// course 0 are the tasks outside courses.
+func (repo *Pgx) LateDaysSpent(userID string, courseID int) (int, error) {
+	var days int
+
+	err := repo.DB.QueryRow(`
+		SELECT COALESCE(SUM(l.days), 0)
+		FROM late_days l
+		JOIN tasks t ON t.id = l.task_id
+		WHERE l.user_id = $1 AND COALESCE(t.course_id, 0) = $2
+	`, userID, courseID).Scan(&days)
+	if err != nil {
+		return 0, err
+	}
+
+	return days, nil
+}
+
+// SpendLateDays makes the user have spent days on the task in the upload
+// transaction tx, false means that doesn't fit the course budget and
+// nothing was spent. Days already spent on the task count, a later upload
+// pays only the difference. The caller holds the lock on the user row, so
+// uploads of one user spend the budget one at a time.
+func SpendLateDays(tx *sql.Tx, userID string, taskID, courseID, days, budget int) (bool, error) {
+	var spent, onTask int
+	err := tx.QueryRow(`
+		SELECT COALESCE(SUM(l.days), 0), COALESCE(SUM(l.days) FILTER (WHERE l.task_id = $2), 0)
+		FROM late_days l
+		JOIN tasks t ON t.id = l.task_id
+		WHERE l.user_id = $1 AND COALESCE(t.course_id, 0) = $3
+	`, userID, taskID, courseID).Scan(&spent, &onTask)
+	if err != nil {
+		return false, err
+	}
+
+	if days <= onTask {
+		return true, nil
+	}
+	if spent-onTask+days > budget {
+		return false, nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO late_days (user_id, task_id, days)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, task_id) DO UPDATE
+		SET days = EXCLUDED.days, updated_at = NOW()
+	`, userID, taskID, days)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+const extensionColumns = `e.id, e.user_id, u.username, e.task_id, e.soft_deadline, e.hard_deadline, e.reason,
+		e.granted_by, e.created_at`
+
+type scanner interface {
+	Scan(...interface{}) error
+}
+
+func scanExtension(row scanner) (*extension.Extension, error) {
+	e := &extension.Extension{}
+	var userID int64
+	var hard sql.NullTime
+
+	err := row.Scan(
+		&e.ID,
+		&userID,
+		&e.Username,
+		&e.TaskID,
+		&e.SoftDeadline,
+		&hard,
+		&e.Reason,
+		&e.GrantedBy,
+		&e.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	e.UserID = strconv.FormatInt(userID, 10)
+	e.HardDeadline = hard.Time
+
+	return e, nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{
+		Time:  t,
+		Valid: !t.IsZero(),
+	}
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	courseService "grader/pkg/server/course/service"
+	"grader/pkg/server/extension"
+	"grader/pkg/server/extension/repo"
+	"grader/pkg/server/task"
+	"strconv"
+	"time"
+)
+
+type ExtensionServiceInterface interface {
+	Grant(*extension.Extension) error
+	GetExtension(string, int) (*extension.Extension, error)
+	GetExtensionsByTaskUsers(int, []string) ([]*extension.Extension, error)
+	LateDaysLeft(string, int) (int, error)
+	Admit(*task.Task, string, time.Time) (*extension.Admission, error)
+}
+
+type ExtensionService struct {
+	ExtensionRepoPQ repo.ExtensionRepoInterface
+	CourseService   courseService.CourseServiceInterface
+	// LateDays is the late-day budget for tasks outside courses, courses
+	// set their own. 0 means none.
+	LateDays int
+}
+
+func NewExtensionService(pgx repo.ExtensionRepoInterface, courses courseService.CourseServiceInterface, lateDays int) *ExtensionService {
+	return &ExtensionService{
+		ExtensionRepoPQ: pgx,
+		CourseService:   courses,
+		LateDays:        lateDays,
+	}
+}
+
+// budget is the late days a user has for the tasks of the course.
+func (h *ExtensionService) budget(courseID int) (int, error) {
+	if courseID == 0 {
+		return h.LateDays, nil
+	}
+
+	c, err := h.CourseService.GetCourseByID(strconv.Itoa(courseID))
+	if err != nil {
+		return 0, err
+	}
+
+	return c.LateDays, nil
+}
+
+func (h *ExtensionService) Grant(e *extension.Extension) error {
+	if e.Reason == "" || e.SoftDeadline.IsZero() {
+		return extension.ErrBadExtension
+	}
+	if !e.HardDeadline.IsZero() && e.HardDeadline.Before(e.SoftDeadline) {
+		return extension.ErrBadExtension
+	}
+
+	return h.ExtensionRepoPQ.Grant(e)
+}
+
+// GetExtension returns the extension of the user for the task, nil when
+// there is none.
+func (h *ExtensionService) GetExtension(userID string, taskID int) (*extension.Extension, error) {
+	e, err := h.ExtensionRepoPQ.Get(userID, taskID)
+	if err == extension.ErrNoExtension {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// GetExtensionsByTaskUsers returns the extensions of the users for the
+// task, those of the students on a page of its solutions.
+func (h *ExtensionService) GetExtensionsByTaskUsers(taskID int, userIDs []string) ([]*extension.Extension, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+
+	return h.ExtensionRepoPQ.ListByTaskUsers(taskID, userIDs)
+}
+
+// LateDaysLeft is what the user has left of the budget of the course.
+func (h *ExtensionService) LateDaysLeft(userID string, courseID int) (int, error) {
+	budget, err := h.budget(courseID)
+	if err != nil {
+		return 0, err
+	}
+
+	spent, err := h.ExtensionRepoPQ.LateDaysSpent(userID, courseID)
+	if err != nil {
+		return 0, err
+	}
+
+	if spent > budget {
+		return 0, nil
+	}
+
+	return budget - spent, nil
+}
+
+// Admit checks an upload of the user at t against the task deadlines, moved
+// by their extension if they have one. A late upload spends late days when
+// the course budget covers it, otherwise the task late policy applies; the
+// days are spent when the upload is stored, see Admission.
+func (h *ExtensionService) Admit(t *task.Task, userID string, at time.Time) (*extension.Admission, error) {
+	policy := t.Policy
+
+	e, err := h.GetExtension(userID, t.ID)
+	if err != nil {
+		return nil, err
+	}
+	if e != nil {
+		policy = e.Apply(policy)
+	}
+
+	if policy.Closed(at) {
+		return nil, task.ErrPastDeadline
+	}
+
+	deadline := policy.NextDeadline(at)
+
+	late, penalty, err := policy.Admit(at)
+	if err != nil && err != task.ErrPastDeadline {
+		return nil, err
+	}
+
+	var byPolicy *extension.Admission
+	if err == nil {
+		byPolicy = &extension.Admission{
+			Late:     late,
+			Penalty:  penalty,
+			Deadline: deadline,
+		}
+	}
+
+	late = policy.Lateness(at)
+	if late > 0 {
+		budget, err := h.budget(t.CourseID)
+		if err != nil {
+			return nil, err
+		}
+
+		if budget > 0 {
+			return &extension.Admission{
+				Late:     late,
+				LateDays: task.DaysLate(late),
+				Budget:   budget,
+				CourseID: t.CourseID,
+				Fallback: byPolicy,
+				Deadline: deadline,
+			}, nil
+		}
+	}
+
+	if byPolicy == nil {
+		return nil, task.ErrPastDeadline
+	}
+
+	return byPolicy, nil
+}
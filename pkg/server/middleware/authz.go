@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"fmt"
+	"grader/pkg/server/authz"
+	"grader/pkg/server/authz/service"
+	"grader/pkg/server/course"
+	"grader/pkg/server/session"
+	"grader/pkg/server/solution"
+	"grader/pkg/server/task"
+	"grader/pkg/utils"
+	"net/http"
+)
+
+// Authorize lets through only requests whose subject has the permission on
+// the target, and puts the decision into the request context. Pages send
+// those who may not see them back to their tasks.
+func Authorize(policy service.PolicyInterface, p authz.Permission, target authz.TargetFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			s, ok := authz.SubjectFromContext(ctx)
+			if !ok {
+				sess, err := session.SessionFromContext(ctx)
+				if err != nil {
+					http.Error(w, "Authorization error", http.StatusUnauthorized)
+					return
+				}
+
+				s = authz.Subject{
+					UserID:   sess.User.ID,
+					Username: sess.User.Username,
+				}
+			}
+
+			t, err := target(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			d, err := policy.Authorize(s, p, t)
+			switch err {
+			case nil:
+			case authz.ErrForbidden:
+				utils.GetLogger(ctx).Warnw("Access denied",
+					"user", s.UserID,
+					"grader", s.Grader,
+					"permission", p,
+					"url", r.URL.Path,
+				)
+				if r.Method == http.MethodGet && s.Username != "" {
+					url := fmt.Sprintf("/tasks/user/%s", s.Username)
+					http.Redirect(w, r, url, http.StatusFound)
+					return
+				}
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			case task.ErrNoTask, solution.ErrNoSolution, course.ErrNoCourse:
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			default:
+				utils.GetLogger(ctx).Errorw("Authorization failed", "error", err)
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(authz.WithDecision(ctx, d)))
+		})
+	}
+}
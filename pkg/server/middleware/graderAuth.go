@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"grader/pkg/graderauth"
+	"grader/pkg/server/authz"
+	"grader/pkg/utils"
+	"net/http"
+)
+
+// GraderAuth lets through only requests signed by a registered grader and
+// makes the grader their subject.
+func GraderAuth(v *graderauth.Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err := v.Verify(r)
+			if err != nil {
+				utils.GetLogger(r.Context()).Warnw("Grader request rejected",
+					"key", r.Header.Get(graderauth.KeyHeader),
+					"url", r.URL.Path,
+					"error", err,
+				)
+				if err == graderauth.ErrTooLarge {
+					http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+					return
+				}
+				http.Error(w, "Authorization error", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := authz.WithSubject(r.Context(), authz.Subject{
+				Grader: r.Header.Get(graderauth.KeyHeader),
+			})
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
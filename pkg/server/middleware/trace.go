@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"grader/pkg/tracing"
+	"grader/pkg/utils"
+	"net/http"
+)
+
+// Trace continues the trace from the traceparent header, or starts one,
+// with a span around the request. It must run inside ReqID.
+func Trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracing.ExtractHTTP(r.Context(), r.Header)
+		ctx, span := tracing.Start(ctx, "HTTP "+r.Method,
+			attribute.String("http.method", r.Method),
+			attribute.String("http.target", r.URL.Path),
+			attribute.String("request.id", utils.GetRequestIDFromContext(ctx)),
+		)
+		defer span.End()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+
+		if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(r.Method + " " + rctx.RoutePattern())
+		}
+	})
+}
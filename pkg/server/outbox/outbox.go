@@ -0,0 +1,15 @@
+package outbox
+
+import "time"
+
+// Message is a broker publish recorded in the same transaction as the
+// data change it announces.
+type Message struct {
+	ID         int64
+	Exchange   string
+	RoutingKey string
+	Headers    map[string]interface{}
+	Priority   uint8
+	Body       []byte
+	CreatedAt  time.Time
+}
@@ -0,0 +1,137 @@
+package repo
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/lib/pq"
+	"grader/pkg/server/outbox"
+	"time"
+)
+
+type Pgx struct {
+	DB *sql.DB
+}
+
+type OutboxRepoInterface interface {
+	Relay(int, func([]*outbox.Message) ([]int64, error)) (int, error)
+	Prune(time.Time) (int64, error)
+}
+
+func NewPgxRepo(db *sql.DB) *Pgx {
+	return &Pgx{
+		DB: db,
+	}
+}
+
+// Add stores m within tx, so it's only relayed if tx commits.
+func Add(tx *sql.Tx, m *outbox.Message) error {
+	headersJson, err := json.Marshal(m.Headers)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO outbox (exchange, routing_key, headers, priority, body)
+		VALUES ($1, $2, $3, $4, $5)
+	`, m.Exchange, m.RoutingKey, headersJson, m.Priority, m.Body)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Relay passes up to limit unsent messages, oldest first, to publish and
+// marks sent the ones it returns, those the broker acked. The rest stay
+// unsent for the next round, also when publish fails. Rows are locked, so
+// concurrent relays skip them.
+func (repo *Pgx) Relay(limit int, publish func([]*outbox.Message) ([]int64, error)) (int, error) {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id, exchange, routing_key, headers, priority, body, created_at
+		FROM outbox
+		WHERE sent_at IS NULL
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	var messages []*outbox.Message
+
+	for rows.Next() {
+		var m outbox.Message
+		var headersJSON []byte
+
+		err = rows.Scan(
+			&m.ID,
+			&m.Exchange,
+			&m.RoutingKey,
+			&headersJSON,
+			&m.Priority,
+			&m.Body,
+			&m.CreatedAt,
+		)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		err = json.Unmarshal(headersJSON, &m.Headers)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		messages = append(messages, &m)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(messages) == 0 {
+		return 0, nil
+	}
+
+	acked, publishErr := publish(messages)
+
+	if len(acked) > 0 {
+		_, err = tx.Exec(`
+			UPDATE outbox
+			SET sent_at = NOW()
+			WHERE id = ANY($1)
+		`, pq.Array(acked))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(acked), publishErr
+}
+
+// Prune deletes the messages sent before the time.
+func (repo *Pgx) Prune(before time.Time) (int64, error) {
+	res, err := repo.DB.Exec(`
+		DELETE FROM outbox
+		WHERE sent_at < $1
+	`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/streadway/amqp"
+	"go.uber.org/zap"
+	"grader/pkg/queue"
+	"grader/pkg/server/outbox"
+	"grader/pkg/server/outbox/repo"
+	"time"
+)
+
+const (
+	relayBatch = 100
+	// confirmTimeout is how long a batch waits for the broker to confirm
+	// it, unconfirmed messages are sent again on the next round.
+	confirmTimeout = 10 * time.Second
+	pruneInterval  = time.Hour
+)
+
+var (
+	errNacked         = errors.New("rabbit nacked outbox messages, they wait for the next round")
+	errConfirmTimeout = errors.New("rabbit did not confirm outbox messages in time, they wait for the next round")
+	errConfirmClosed  = errors.New("rabbit channel closed before confirming outbox messages")
+)
+
+type Notifier interface {
+	Notify()
+}
+
+// Relay publishes outbox messages to the broker. It polls every Interval
+// and right away after Notify. Messages count as sent once the broker
+// confirms them, and are deleted Retention after that.
+type Relay struct {
+	OutboxRepo repo.OutboxRepoInterface
+	Broker     *queue.Connection
+	Logger     *zap.Logger
+	Interval   time.Duration
+	Retention  time.Duration
+
+	notify chan struct{}
+}
+
+func NewRelay(outboxRepo repo.OutboxRepoInterface, broker *queue.Connection, logger *zap.Logger, interval, retention time.Duration) *Relay {
+	return &Relay{
+		OutboxRepo: outboxRepo,
+		Broker:     broker,
+		Logger:     logger,
+		Interval:   interval,
+		Retention:  retention,
+		notify:     make(chan struct{}, 1),
+	}
+}
+
+func (r *Relay) Notify() {
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	pruneTicker := time.NewTicker(pruneInterval)
+	defer pruneTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-pruneTicker.C:
+			r.prune()
+			continue
+		case <-ticker.C:
+		case <-r.notify:
+		}
+
+		r.relay()
+	}
+}
+
+func (r *Relay) prune() {
+	n, err := r.OutboxRepo.Prune(time.Now().Add(-r.Retention))
+	if err != nil {
+		r.Logger.Error("cant prune outbox", zap.Error(err))
+		return
+	}
+	if n > 0 {
+		r.Logger.Info("pruned sent outbox messages", zap.Int64("count", n))
+	}
+}
+
+func (r *Relay) relay() {
+	for {
+		sent, err := r.OutboxRepo.Relay(relayBatch, r.publish)
+		if err == queue.ErrNotConnected {
+			r.Logger.Warn("rabbit is not connected, outbox messages wait")
+			return
+		}
+		if err != nil {
+			r.Logger.Error("cant relay outbox", zap.Error(err))
+			return
+		}
+
+		if sent < relayBatch {
+			return
+		}
+	}
+}
+
+// publish sends the messages on a confirm channel and returns the ids of
+// those the broker acked. It stops sending at the first error, but still
+// collects the confirms of what went out before it.
+func (r *Relay) publish(messages []*outbox.Message) ([]int64, error) {
+	ch, err := r.Broker.ConfirmChannel()
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, len(messages)))
+
+	var published []*outbox.Message
+	var publishErr error
+
+	for _, m := range messages {
+		publishErr = publishOne(ch, m)
+		if publishErr != nil {
+			break
+		}
+
+		published = append(published, m)
+	}
+
+	var acked []int64
+	nacked := 0
+	timeout := time.NewTimer(confirmTimeout)
+	defer timeout.Stop()
+
+	// Delivery tags of a new channel count publishes from 1.
+	for range published {
+		select {
+		case c, ok := <-confirms:
+			if !ok {
+				return acked, errConfirmClosed
+			}
+			if !c.Ack {
+				nacked++
+				continue
+			}
+
+			m := published[c.DeliveryTag-1]
+			acked = append(acked, m.ID)
+			queue.PublishedTotal.WithLabelValues(m.Exchange, m.RoutingKey).Inc()
+		case <-timeout.C:
+			return acked, errConfirmTimeout
+		}
+	}
+
+	if publishErr != nil {
+		return acked, publishErr
+	}
+	if nacked > 0 {
+		return acked, errNacked
+	}
+
+	return acked, nil
+}
+
+func publishOne(ch *amqp.Channel, m *outbox.Message) error {
+	// Jobs wait in per-capability queues that may not be declared yet.
+	if m.Exchange == queue.SolutionExchangeName {
+		_, err := queue.DeclareRoute(ch, m.RoutingKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	return ch.Publish(
+		m.Exchange,
+		m.RoutingKey,
+		false,
+		false,
+		amqp.Publishing{
+			Headers:      amqp.Table(m.Headers),
+			DeliveryMode: amqp.Persistent,
+			Priority:     m.Priority,
+			ContentType:  "application/json",
+			Body:         m.Body,
+		})
+}
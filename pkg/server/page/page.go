@@ -0,0 +1,233 @@
+// Package page windows the lists of the repos: how many rows, from where
+// and in what order, read from the query of a request.
+package page
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultLimit is the page size of requests that don't ask for one.
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+// DateLayout is what date inputs send.
+const DateLayout = "2006-01-02"
+
+var (
+	ErrBadPage   = errors.New("bad page: limit and offset must be non-negative numbers, limit at most 500, and sort one of the sort keys, - in front to reverse")
+	ErrBadFilter = errors.New("bad filter: unknown value or a date not like 2006-01-02")
+)
+
+// Page is a window of a sorted list. The zero Page is the whole list in
+// its default order.
+type Page struct {
+	// Limit 0 means no limit.
+	Limit  int
+	Offset int
+	// Sort is the key to order by, empty for the default order of the
+	// list, Desc reverses it.
+	Sort string
+	Desc bool
+}
+
+// FromRequest reads limit, offset and sort from the query, sort=-name is
+// by name descending. Requests without a limit get DefaultLimit.
+func FromRequest(r *http.Request) (Page, error) {
+	p := Page{Limit: DefaultLimit}
+
+	var err error
+	if v := r.FormValue("limit"); v != "" {
+		p.Limit, err = strconv.Atoi(v)
+		if err != nil || p.Limit <= 0 || p.Limit > MaxLimit {
+			return Page{}, ErrBadPage
+		}
+	}
+	if v := r.FormValue("offset"); v != "" {
+		p.Offset, err = strconv.Atoi(v)
+		if err != nil || p.Offset < 0 {
+			return Page{}, ErrBadPage
+		}
+	}
+
+	p.Sort, p.Desc = parseSort(r.FormValue("sort"))
+
+	return p, nil
+}
+
+func parseSort(v string) (string, bool) {
+	if strings.HasPrefix(v, "-") {
+		return v[1:], true
+	}
+
+	return v, false
+}
+
+// SQL is the ORDER BY, LIMIT and OFFSET clauses of the page. keys maps the
+// sort keys to the expressions they order by, def is the key used when
+// the page names none, "-" in front to reverse. Ties go by id so pages
+// don't overlap.
+func (p Page) SQL(keys map[string]string, def string) (string, error) {
+	sort, desc := p.Sort, p.Desc
+	if sort == "" {
+		sort, desc = parseSort(def)
+	}
+
+	expr, ok := keys[sort]
+	if !ok || p.Limit < 0 || p.Offset < 0 {
+		return "", ErrBadPage
+	}
+
+	dir := "ASC"
+	if desc {
+		dir = "DESC"
+	}
+
+	clause := fmt.Sprintf("ORDER BY %s %s NULLS LAST, id %s", expr, dir, dir)
+	if p.Limit > 0 {
+		clause += fmt.Sprintf(" LIMIT %d", p.Limit)
+	}
+	if p.Offset > 0 {
+		clause += fmt.Sprintf(" OFFSET %d", p.Offset)
+	}
+
+	return clause, nil
+}
+
+// DateRange reads the from and to dates of the query in server local
+// time. To is the end of its day, so both ends are included.
+func DateRange(r *http.Request) (from, to time.Time, err error) {
+	if v := r.FormValue("from"); v != "" {
+		from, err = time.ParseInLocation(DateLayout, v, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, ErrBadFilter
+		}
+	}
+	if v := r.FormValue("to"); v != "" {
+		to, err = time.ParseInLocation(DateLayout, v, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, ErrBadFilter
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+
+	return from, to, nil
+}
+
+// Where collects the conditions of a filtered list and their arguments,
+// numbering the placeholders.
+type Where struct {
+	conds []string
+	Args  []interface{}
+}
+
+// Add appends a condition with one placeholder, written as ?.
+func (w *Where) Add(cond string, arg interface{}) {
+	w.Args = append(w.Args, arg)
+	w.conds = append(w.conds, strings.Replace(cond, "?", "$"+strconv.Itoa(len(w.Args)), 1))
+}
+
+// AddRaw appends a condition without arguments.
+func (w *Where) AddRaw(cond string) {
+	w.conds = append(w.conds, cond)
+}
+
+func (w *Where) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+
+	return "WHERE " + strings.Join(w.conds, " AND ")
+}
+
+// Pager links the pages of a list on the admin pages. Query is the one of
+// the request, the links keep its filters.
+type Pager struct {
+	Page
+	Total int
+	Query url.Values
+}
+
+func NewPager(r *http.Request, p Page, total int) *Pager {
+	return &Pager{
+		Page:  p,
+		Total: total,
+		Query: r.URL.Query(),
+	}
+}
+
+// First is the number of the first row on the page, counting from 1.
+func (p *Pager) First() int {
+	if p.Total == 0 {
+		return 0
+	}
+
+	return p.Offset + 1
+}
+
+// Last is the number of the last row on the page.
+func (p *Pager) Last() int {
+	if p.Limit == 0 || p.Offset+p.Limit > p.Total {
+		return p.Total
+	}
+
+	return p.Offset + p.Limit
+}
+
+// Prev is the query of the page before, empty on the first page.
+func (p *Pager) Prev() string {
+	if p.Offset == 0 {
+		return ""
+	}
+
+	offset := p.Offset - p.Limit
+	if offset < 0 {
+		offset = 0
+	}
+
+	return p.at(offset)
+}
+
+// Next is the query of the page after, empty on the last page.
+func (p *Pager) Next() string {
+	if p.Limit == 0 || p.Offset+p.Limit >= p.Total {
+		return ""
+	}
+
+	return p.at(p.Offset + p.Limit)
+}
+
+func (p *Pager) at(offset int) string {
+	q := url.Values{}
+	for k, v := range p.Query {
+		q[k] = v
+	}
+	q.Set("offset", strconv.Itoa(offset))
+
+	return "?" + q.Encode()
+}
+
+// List is a page of a list as the JSON API answers it.
+type List struct {
+	Items  interface{} `json:"items"`
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+}
+
+// NewList answers the page with the items, Total rows in all.
+func NewList(items interface{}, total int, p Page) *List {
+	return &List{
+		Items:  items,
+		Total:  total,
+		Limit:  p.Limit,
+		Offset: p.Offset,
+	}
+}
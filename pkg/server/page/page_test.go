@@ -0,0 +1,154 @@
+package page
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestWhere(t *testing.T) {
+	tests := []struct {
+		name     string
+		build    func(*Where)
+		want     string
+		wantArgs []interface{}
+	}{
+		{
+			name:  "empty",
+			build: func(*Where) {},
+			want:  "",
+		},
+		{
+			name: "one condition",
+			build: func(w *Where) {
+				w.Add("task_id = ?", 7)
+			},
+			want:     "WHERE task_id = $1",
+			wantArgs: []interface{}{7},
+		},
+		{
+			name: "numbered in order",
+			build: func(w *Where) {
+				w.Add("task_id = ?", 7)
+				w.AddRaw("deleted_at IS NULL")
+				w.Add("name ILIKE ?", "%go%")
+				w.Add("created_at >= ?", "2024-01-01")
+			},
+			want:     "WHERE task_id = $1 AND deleted_at IS NULL AND name ILIKE $2 AND created_at >= $3",
+			wantArgs: []interface{}{7, "%go%", "2024-01-01"},
+		},
+		{
+			name: "raw only",
+			build: func(w *Where) {
+				w.AddRaw("course_id IS NULL")
+			},
+			want: "WHERE course_id IS NULL",
+		},
+		{
+			name: "array argument",
+			build: func(w *Where) {
+				w.Add("user_id = ANY(?)", []string{"1", "2"})
+			},
+			want:     "WHERE user_id = ANY($1)",
+			wantArgs: []interface{}{[]string{"1", "2"}},
+		},
+	}
+
+	for _, tt := range tests {
+		w := &Where{}
+		tt.build(w)
+
+		if got := w.String(); got != tt.want {
+			t.Errorf("%s: String() = %q, want %q", tt.name, got, tt.want)
+		}
+		if !reflect.DeepEqual(w.Args, tt.wantArgs) {
+			t.Errorf("%s: Args = %v, want %v", tt.name, w.Args, tt.wantArgs)
+		}
+	}
+}
+
+func TestPageSQL(t *testing.T) {
+	keys := map[string]string{
+		"name":    "name",
+		"created": "created_at",
+	}
+
+	tests := []struct {
+		name    string
+		page    Page
+		def     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "default order",
+			def:  "-created",
+			want: "ORDER BY created_at DESC NULLS LAST, id DESC",
+		},
+		{
+			name: "sorted and windowed",
+			page: Page{Limit: 50, Offset: 100, Sort: "name"},
+			def:  "-created",
+			want: "ORDER BY name ASC NULLS LAST, id ASC LIMIT 50 OFFSET 100",
+		},
+		{
+			name: "reversed",
+			page: Page{Sort: "name", Desc: true},
+			def:  "created",
+			want: "ORDER BY name DESC NULLS LAST, id DESC",
+		},
+		{
+			name:    "unknown key",
+			page:    Page{Sort: "password"},
+			def:     "created",
+			wantErr: true,
+		},
+		{
+			name:    "negative offset",
+			page:    Page{Offset: -1},
+			def:     "created",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.page.SQL(keys, tt.def)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: SQL() error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: SQL() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    Page
+		wantErr bool
+	}{
+		{query: "", want: Page{Limit: DefaultLimit}},
+		{query: "limit=10&offset=20&sort=-name", want: Page{Limit: 10, Offset: 20, Sort: "name", Desc: true}},
+		{query: "sort=created", want: Page{Limit: DefaultLimit, Sort: "created"}},
+		{query: "limit=500", want: Page{Limit: MaxLimit}},
+		{query: "limit=501", wantErr: true},
+		{query: "limit=0", wantErr: true},
+		{query: "limit=ten", wantErr: true},
+		{query: "offset=-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/tasks?"+tt.query, nil)
+
+		got, err := FromRequest(r)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("FromRequest(%q) error = %v, want error %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("FromRequest(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
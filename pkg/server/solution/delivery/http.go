@@ -4,23 +4,45 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 	"grader/pkg/queue"
+	"grader/pkg/server/authz"
 	"grader/pkg/server/session"
 	"grader/pkg/server/solution"
 	"grader/pkg/server/solution/service"
+	"grader/pkg/server/task"
+	taskService "grader/pkg/server/task/service"
+	"grader/pkg/server/user"
+	"grader/pkg/tracing"
 	"grader/pkg/utils"
+	"html/template"
 	"io"
 	"net/http"
+	"time"
 )
 
+const recoveriesOnDashboard = 100
+
+type JobsData struct {
+	User       *user.Claims
+	Lease      time.Duration
+	MaxRetries int
+	Stuck      []*solution.Solution
+	Recoveries []*solution.Recovery
+}
+
 type SolutionHandler struct {
+	Tmpl            *template.Template
 	SolutionService service.SolutionServiceInterface
-	RabbitChan      *amqp.Channel
+	Reaper          *service.Reaper
+	TaskService     taskService.TaskServiceInterface
 }
 
 func (h *SolutionHandler) SolutionResult(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+	ctx, span := tracing.Start(r.Context(), "SolutionResult")
+	defer span.End()
+
 	if r.Method != http.MethodPost {
 		utils.GetLogger(ctx).Error("Error retrieving file", "not webhook")
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -29,17 +51,40 @@ func (h *SolutionHandler) SolutionResult(w http.ResponseWriter, r *http.Request)
 
 	defer r.Body.Close()
 
-	s := &solution.Solution{}
+	res := &queue.Result{}
 
-	err := utils.DecodeJSONHandler(w, r, &s)
+	err := utils.DecodeJSONHandler(w, r, res)
 	if err != nil {
 		utils.GetLogger(ctx).Error("Error decoding request body", zap.Error(err))
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	err = h.SolutionService.UpdateSolution(s)
+	if res.Version != queue.JobVersion {
+		utils.GetLogger(ctx).Error("Unsupported result version", zap.Int("version", res.Version))
+		http.Error(w, "Unsupported result version", http.StatusBadRequest)
+		return
+	}
+
+	span.SetAttributes(
+		attribute.Int("solution.id", res.SolutionID),
+		attribute.Int("solution.attempt", res.Attempt),
+		attribute.Bool("solution.pass", res.Pass),
+	)
+
+	err = h.SolutionService.ApplyResult(res)
+	if err == solution.ErrStaleResult {
+		// A re-delivered or superseded result, the grader needs no retry.
+		utils.GetLogger(ctx).Info("Stale result ignored", zap.Int("solution", res.SolutionID), zap.Int("attempt", res.Attempt))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err == solution.ErrBadTransition || err == solution.ErrStatusChanged {
+		utils.GetLogger(ctx).Warn("Result rejected", zap.Int("solution", res.SolutionID), zap.Error(err))
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
+		tracing.Fail(span, err)
 		utils.GetLogger(ctx).Error("Error update solution", zap.Error(err))
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -48,8 +93,74 @@ func (h *SolutionHandler) SolutionResult(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusOK)
 }
 
-func (h *SolutionHandler) UploadSolution(w http.ResponseWriter, r *http.Request) {
+// SolutionStatus takes progress reports from graders.
+func (h *SolutionHandler) SolutionStatus(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	defer r.Body.Close()
+
+	ev := &queue.StatusEvent{}
+
+	err := utils.DecodeJSONHandler(w, r, ev)
+	if err != nil {
+		utils.GetLogger(ctx).Error("Error decoding request body", zap.Error(err))
+		return
+	}
+
+	if ev.Version != queue.JobVersion {
+		utils.GetLogger(ctx).Error("Unsupported status version", zap.Int("version", ev.Version))
+		http.Error(w, "Unsupported status version", http.StatusBadRequest)
+		return
+	}
+
+	err = h.SolutionService.ApplyStatus(ev, solution.ActorGrader)
+	if err == solution.ErrBadTransition || err == solution.ErrStatusChanged {
+		utils.GetLogger(ctx).Warn("Status rejected", zap.Int("solution", ev.SolutionID), zap.String("status", ev.Status), zap.Error(err))
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("Error update solution status", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// ConsumeStatusEvents applies the status events the dispatcher publishes
+// until deliveries is closed.
+func (h *SolutionHandler) ConsumeStatusEvents(deliveries <-chan amqp.Delivery, logger *zap.Logger) {
+	for d := range deliveries {
+		ev := &queue.StatusEvent{}
+
+		err := json.Unmarshal(d.Body, ev)
+		if err != nil || ev.Version != queue.JobVersion {
+			logger.Error("Bad status event", zap.ByteString("body", d.Body), zap.Error(err))
+			d.Ack(false)
+			continue
+		}
+
+		err = h.SolutionService.ApplyStatus(ev, solution.ActorQueue)
+		if err == solution.ErrBadTransition || err == solution.ErrStatusChanged {
+			// The grader got ahead of the dispatcher report.
+			logger.Debug("Status event skipped", zap.Int("solution", ev.SolutionID), zap.String("status", ev.Status))
+			d.Ack(false)
+			continue
+		}
+		if err != nil {
+			logger.Error("Error update solution status", zap.Int("solution", ev.SolutionID), zap.Error(err))
+			d.Nack(false, true)
+			continue
+		}
+
+		d.Ack(false)
+	}
+}
+
+func (h *SolutionHandler) UploadSolution(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Start(r.Context(), "UploadSolution")
+	defer span.End()
+
 	taskID := r.FormValue("id")
 
 	file, fileHeader, err := r.FormFile("file")
@@ -67,44 +178,173 @@ func (h *SolutionHandler) UploadSolution(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	sess, _ := session.SessionFromContext(ctx)
+	sess, err := session.SessionFromContext(ctx)
 	if err != nil {
 		utils.GetLogger(ctx).Error("Bad session", zap.Error(err))
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	s, err := h.SolutionService.UploadSolution(taskID, sess, fileBytes, fileHeader)
+	t, err := h.TaskService.GetTaskByID(taskID)
 	if err != nil {
-		utils.GetLogger(ctx).Error("Error uploading solution", zap.Error(err))
+		utils.GetLogger(ctx).Error("Error get task", zap.Error(err))
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	data, err := json.Marshal(&s)
+	d, err := authz.FromContext(ctx)
 	if err != nil {
-		utils.GetLogger(ctx).Error("Error marshal solution", zap.Error(err))
+		utils.GetLogger(ctx).Error("Error get authorization", zap.Error(err))
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	err = h.RabbitChan.Publish(
-		"",
-		queue.SolutionQueueName,
-		false,
-		false,
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "text/plain",
-			Body:         data,
-		})
+	err = t.Accepts(time.Now(), d.Staff())
+	if err == task.ErrNoTask {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	span.SetAttributes(attribute.Int("task.id", t.ID))
 
+	s, err := h.SolutionService.UploadSolution(ctx, t, sess, fileBytes, fileHeader, d.Staff())
+	if err == solution.ErrTooManyInFlight {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err == task.ErrPastDeadline {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
-		utils.GetLogger(ctx).Error("Error publish solution to queue", zap.Error(err))
+		tracing.Fail(span, err)
+		utils.GetLogger(ctx).Error("Error uploading solution", zap.Error(err))
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
+	span.SetAttributes(attribute.Int("solution.id", s.ID))
+
 	url := fmt.Sprintf("/tasks/%s/solutions/%d", taskID, s.ID)
 	http.Redirect(w, r, url, http.StatusFound)
 }
+
+// CancelSolution lets the author or their staff stop grading of a solution.
+func (h *SolutionHandler) CancelSolution(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	solutionID := r.FormValue("id")
+
+	sess, err := session.SessionFromContext(ctx)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get session from context", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	s, err := h.SolutionService.GetSolutionByID(solutionID)
+	if err == solution.ErrNoSolution {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("Error get solution", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	author := s.User.ID == sess.User.ID
+
+	err = h.SolutionService.CancelSolution(s, solution.ActorServer)
+	if err == solution.ErrBadTransition || err == solution.ErrStatusChanged {
+		http.Error(w, "Solution is not waiting for grading", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("Error cancel solution", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	url := fmt.Sprintf("/tasks/%d/solutions/%d", s.TaskID, s.ID)
+	if !author {
+		url = fmt.Sprintf("/tasks/admin/task/%d/solutions", s.TaskID)
+	}
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func (h *SolutionHandler) RegradeTask(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	taskID := r.FormValue("id")
+
+	t, err := h.TaskService.GetTaskByID(taskID)
+	if err != nil {
+		utils.GetLogger(ctx).Error("Error get task", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if t.Archived() {
+		http.Error(w, task.ErrArchived.Error(), http.StatusForbidden)
+		return
+	}
+
+	requeued, failed, err := h.SolutionService.RegradeByTaskID(t)
+	if err != nil {
+		utils.GetLogger(ctx).Error("Error regrade solutions",
+			zap.Int("requeued", requeued),
+			zap.Int("failed", failed),
+			zap.Error(err),
+		)
+		msg := "internal error"
+		if failed > 0 {
+			msg = fmt.Sprintf("requeued %d solutions, %d failed, regrade again to retry them", requeued, failed)
+		}
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+
+	url := fmt.Sprintf("/tasks/admin/task/%s/solutions", taskID)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func (h *SolutionHandler) StuckJobs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	sess, err := session.SessionFromContext(ctx)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get session from context", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	stuck, err := h.Reaper.Stuck()
+	if err != nil {
+		utils.GetLogger(ctx).Error("Error get stuck solutions", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	recoveries, err := h.Reaper.Recoveries(recoveriesOnDashboard)
+	if err != nil {
+		utils.GetLogger(ctx).Error("Error get recoveries", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.Tmpl.ExecuteTemplate(w, "jobs.html", &JobsData{
+		User:       sess.User,
+		Lease:      h.Reaper.Lease,
+		MaxRetries: h.Reaper.MaxRetries,
+		Stuck:      stuck,
+		Recoveries: recoveries,
+	})
+	if err != nil {
+		utils.GetLogger(ctx).Error("error execute template", zap.Error(err))
+		http.Error(w, `Template error`, http.StatusInternalServerError)
+		return
+	}
+}
@@ -3,19 +3,60 @@ package repo
 import (
 	"database/sql"
 	"encoding/json"
+	"github.com/lib/pq"
+	"grader/pkg/server/extension"
+	extensionRepo "grader/pkg/server/extension/repo"
+	"grader/pkg/server/outbox"
+	outboxRepo "grader/pkg/server/outbox/repo"
+	"grader/pkg/server/page"
 	"grader/pkg/server/solution"
+	"time"
 )
 
 type Pgx struct {
 	DB *sql.DB
 }
 
+// JobBuilder makes the outbox message announcing a stored solution.
+type JobBuilder func(*solution.Solution) (*outbox.Message, error)
+
+// Upload is what storing a new solution checks besides the insert.
+type Upload struct {
+	// MaxInFlight caps the solutions of the user waiting for a result, 0
+	// means no cap.
+	MaxInFlight int
+	// LatestOnly uploads replace the solutions of the user for the task
+	// still queued, those don't count against the cap and are cancelled
+	// with the messages Cancel makes.
+	LatestOnly bool
+	Cancel     JobBuilder
+	// Admission spends the late days of the upload, nil for none.
+	Admission *extension.Admission
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+	Query(string, ...interface{}) (*sql.Rows, error)
+	QueryRow(string, ...interface{}) *sql.Row
+}
+
 type SolutionRepoInterface interface {
 	Add(*solution.Solution) (*solution.Solution, error)
-	Update(*solution.Solution) error
-	GetListByTaskID(int) ([]*solution.Solution, error)
+	AddWithJob(*solution.Solution, *solution.Event, JobBuilder, Upload) (*solution.Solution, error)
+	UpdateWithJob(*solution.Solution, *solution.Event, JobBuilder) error
+	UpdateResult(*solution.Solution, *solution.Result, *solution.Event) error
+	UpdateStatus(*solution.Solution, *solution.Event) error
+	UpdateStatusWithJob(*solution.Solution, *solution.Event, JobBuilder) error
+	ListEventsBySolutionIDs([]int) ([]*solution.Event, error)
+	GetListByTaskID(int, page.Page) ([]*solution.Solution, error)
 	GetByID(int) (*solution.Solution, error)
-	List() ([]*solution.Solution, error)
+	List(solution.Filter, page.Page) ([]*solution.Solution, int, error)
+	ListStale(time.Time) ([]*solution.Solution, error)
+	RequeueWithRecovery(*solution.Solution, *solution.Event, JobBuilder, *solution.Recovery) error
+	FailWithRecovery(*solution.Solution, *solution.Result, *solution.Event, *solution.Recovery) error
+	CountRequeues(int) (int, error)
+	ListRecoveries(int) ([]*solution.Recovery, error)
 }
 
 func NewPgxRepo(db *sql.DB) *Pgx {
@@ -25,6 +66,135 @@ func NewPgxRepo(db *sql.DB) *Pgx {
 }
 
 func (repo *Pgx) Add(s *solution.Solution) (*solution.Solution, error) {
+	return insert(repo.DB, s)
+}
+
+// AddWithJob stores the solution, its first event and its grading job in
+// one transaction, cancelling the solutions it supersedes and spending its
+// late days. It fails with ErrTooManyInFlight when the upload doesn't fit
+// the cap of u, and with ErrPastDeadline when it is rejected as late.
+func (repo *Pgx) AddWithJob(s *solution.Solution, ev *solution.Event, job JobBuilder, u Upload) (*solution.Solution, error) {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Uploads of one user are stored one at a time, so they can't pass the
+	// cap together.
+	_, err = tx.Exec(`SELECT id FROM users WHERE id = $1 FOR UPDATE`, s.User.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.MaxInFlight > 0 {
+		inFlight, err := countInFlight(tx, s.User.ID, s.TaskID, u.LatestOnly)
+		if err != nil {
+			return nil, err
+		}
+		if inFlight >= u.MaxInFlight {
+			return nil, solution.ErrTooManyInFlight
+		}
+	}
+
+	if u.Admission != nil && u.Admission.Spends() {
+		a := u.Admission
+		fits, err := extensionRepo.SpendLateDays(tx, s.User.ID, s.TaskID, a.CourseID, a.LateDays, a.Budget)
+		if err != nil {
+			return nil, err
+		}
+
+		a, err = a.Settle(fits)
+		if err != nil {
+			return nil, err
+		}
+
+		s.Late, s.Penalty, s.LateDays = a.Late, a.Penalty, a.LateDays
+	}
+
+	if u.LatestOnly {
+		err = cancelQueued(tx, s.User.ID, s.TaskID, ev.Actor, u.Cancel)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := insert(tx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	ev.SolutionID = res.ID
+	err = addEvent(tx, ev)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := job(res)
+	if err != nil {
+		return nil, err
+	}
+
+	err = outboxRepo.Add(tx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// cancelQueued cancels the solutions of the user for the task not yet
+// handed to a grader. Ones a grader already has are left to finish, their
+// run is paid for. They are locked, so dispatch reports can't land
+// meanwhile.
+func cancelQueued(tx *sql.Tx, userID string, taskID int, actor string, cancel JobBuilder) error {
+	queued, err := list(tx, `
+		SELECT `+solutionColumns+`
+		FROM solutions
+		WHERE user_data->>'id' = $1 AND task_id = $2 AND status = 'queued'
+		ORDER BY id
+		FOR UPDATE
+	`, userID, taskID)
+	if err != nil {
+		return err
+	}
+
+	for _, old := range queued {
+		if !solution.CanTransition(old.Status, solution.StatusCancelled) {
+			return solution.ErrBadTransition
+		}
+
+		err = updateStatus(tx, old, &solution.Event{
+			SolutionID: old.ID,
+			From:       old.Status,
+			To:         solution.StatusCancelled,
+			Actor:      actor,
+			Attempt:    old.Attempt,
+		})
+		if err != nil {
+			return err
+		}
+
+		m, err := cancel(old)
+		if err != nil {
+			return err
+		}
+
+		err = outboxRepo.Add(tx, m)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func insert(q queryer, s *solution.Solution) (*solution.Solution, error) {
 	var lastInsertId int64
 	res := &solution.Solution{
 		User:      s.User,
@@ -32,6 +202,11 @@ func (repo *Pgx) Add(s *solution.Solution) (*solution.Solution, error) {
 		File:      s.File,
 		Result:    s.Result,
 		Status:    s.Status,
+		Attempt:   s.Attempt,
+		Version:   1,
+		Late:      s.Late,
+		Penalty:   s.Penalty,
+		LateDays:  s.LateDays,
 		CreatedAt: s.CreatedAt,
 	}
 
@@ -48,11 +223,11 @@ func (repo *Pgx) Add(s *solution.Solution) (*solution.Solution, error) {
 		return nil, err
 	}
 
-	row := repo.DB.QueryRow(`
-		INSERT INTO solutions (user_data, task_id, file, result, status, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+	row := q.QueryRow(`
+		INSERT INTO solutions (user_data, task_id, file, result, status, attempt, late_seconds, penalty, late_days, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		RETURNING id
-	`, userJson, s.TaskID, fileJson, resultJson, s.Status, s.CreatedAt)
+	`, userJson, s.TaskID, fileJson, resultJson, s.Status, s.Attempt, int64(s.Late/time.Second), s.Penalty, s.LateDays, s.CreatedAt)
 
 	err = row.Scan(
 		&lastInsertId,
@@ -66,7 +241,69 @@ func (repo *Pgx) Add(s *solution.Solution) (*solution.Solution, error) {
 	return res, nil
 }
 
-func (repo *Pgx) Update(s *solution.Solution) error {
+// UpdateWithJob updates the solution, records the event and stores its
+// grading job in one transaction.
+//
+// Every update is guarded by s.Version, the version s was read at, and
+// fails with ErrStatusChanged if somebody updated the row since. On success
+// s.Version is the new one.
+func (repo *Pgx) UpdateWithJob(s *solution.Solution, ev *solution.Event, job JobBuilder) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateWithJob(tx, s, ev, job)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RequeueWithRecovery is UpdateWithJob also recording the reaper's
+// recovery in the same transaction.
+func (repo *Pgx) RequeueWithRecovery(s *solution.Solution, ev *solution.Event, job JobBuilder, rec *solution.Recovery) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateWithJob(tx, s, ev, job)
+	if err != nil {
+		return err
+	}
+
+	err = addRecovery(tx, rec)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func updateWithJob(tx *sql.Tx, s *solution.Solution, ev *solution.Event, job JobBuilder) error {
+	err := update(tx, s)
+	if err != nil {
+		return err
+	}
+
+	err = addEvent(tx, ev)
+	if err != nil {
+		return err
+	}
+
+	m, err := job(s)
+	if err != nil {
+		return err
+	}
+
+	return outboxRepo.Add(tx, m)
+}
+
+func update(q queryer, s *solution.Solution) error {
 	userJson, err := json.Marshal(s.User)
 	if err != nil {
 		return err
@@ -80,144 +317,184 @@ func (repo *Pgx) Update(s *solution.Solution) error {
 		return err
 	}
 
-	_, err = repo.DB.Exec(`
+	res, err := q.Exec(`
 		UPDATE solutions 
-		SET user_data = $1, task_id = $2, file = $3, result = $4, status = $5, created_at = $6
-		WHERE id = $7
-	`, userJson, s.TaskID, fileJson, resultJson, s.Status, s.CreatedAt, s.ID)
-
+		SET user_data = $1, task_id = $2, file = $3, result = $4, status = $5, attempt = $6, created_at = $7,
+		    updated_at = NOW(), version = version + 1
+		WHERE id = $8 AND version = $9
+	`, userJson, s.TaskID, fileJson, resultJson, s.Status, s.Attempt, s.CreatedAt, s.ID, s.Version)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return checkChanged(res, s)
 }
 
-func (repo *Pgx) List() ([]*solution.Solution, error) {
-	//TODO added with query params limit offset
-	rows, err := repo.DB.Query(`
-		SELECT id, user_data, task_id, file, result, status, created_at
-		FROM solutions
-	`)
+// UpdateResult sets only the grading outcome and status, the rest of the
+// row is owned by the server.
+func (repo *Pgx) UpdateResult(s *solution.Solution, result *solution.Result, ev *solution.Event) error {
+	tx, err := repo.DB.Begin()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var solutions []*solution.Solution
+	err = updateResult(tx, s, result, ev)
+	if err != nil {
+		return err
+	}
 
-	for rows.Next() {
-		var s solution.Solution
-		var userJSON []byte
-		var resultJSON []byte
-		var fileJson []byte
+	return tx.Commit()
+}
 
-		err = rows.Scan(
-			&s.ID,
-			&userJSON,
-			&s.TaskID,
-			&fileJson,
-			&resultJSON,
-			&s.Status,
-			&s.CreatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
+// FailWithRecovery is UpdateResult also recording the reaper's recovery in
+// the same transaction.
+func (repo *Pgx) FailWithRecovery(s *solution.Solution, result *solution.Result, ev *solution.Event, rec *solution.Recovery) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-		err = json.Unmarshal(userJSON, &s.User)
-		if err != nil {
-			return nil, err
-		}
+	err = updateResult(tx, s, result, ev)
+	if err != nil {
+		return err
+	}
 
-		err = json.Unmarshal(resultJSON, &s.Result)
-		if err != nil {
-			return nil, err
-		}
+	err = addRecovery(tx, rec)
+	if err != nil {
+		return err
+	}
 
-		err = json.Unmarshal(fileJson, &s.File)
-		if err != nil {
-			return nil, err
-		}
+	return tx.Commit()
+}
 
-		solutions = append(solutions, &s)
+func updateResult(q queryer, s *solution.Solution, result *solution.Result, ev *solution.Event) error {
+	resultJson, err := json.Marshal(result)
+	if err != nil {
+		return err
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, err
+	res, err := q.Exec(`
+		UPDATE solutions
+		SET result = $1, status = $2, updated_at = NOW(), version = version + 1
+		WHERE id = $3 AND version = $4
+	`, resultJson, ev.To, s.ID, s.Version)
+	if err != nil {
+		return err
 	}
 
-	return solutions, nil
+	err = checkChanged(res, s)
+	if err != nil {
+		return err
+	}
+
+	return addEvent(q, ev)
 }
 
-func (repo *Pgx) GetListByTaskID(taskID int) ([]*solution.Solution, error) {
-	rows, err := repo.DB.Query(`
-		SELECT id, user_data, task_id, file, result, status, created_at
-		FROM solutions
-		WHERE task_id = $1
-	`, taskID)
+// UpdateStatus moves the solution to ev.To and records the event.
+func (repo *Pgx) UpdateStatus(s *solution.Solution, ev *solution.Event) error {
+	tx, err := repo.DB.Begin()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var solutions []*solution.Solution
+	err = updateStatus(tx, s, ev)
+	if err != nil {
+		return err
+	}
 
-	for rows.Next() {
-		var s solution.Solution
-		var userJSON []byte
-		var resultJSON []byte
-		var fileJson []byte
+	return tx.Commit()
+}
 
-		err = rows.Scan(
-			&s.ID,
-			&userJSON,
-			&s.TaskID,
-			&fileJson,
-			&resultJSON,
-			&s.Status,
-			&s.CreatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
+// UpdateStatusWithJob is UpdateStatus also storing the message job makes
+// for s in the same transaction.
+func (repo *Pgx) UpdateStatusWithJob(s *solution.Solution, ev *solution.Event, job JobBuilder) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-		err = json.Unmarshal(userJSON, &s.User)
-		if err != nil {
-			return nil, err
-		}
+	err = updateStatus(tx, s, ev)
+	if err != nil {
+		return err
+	}
 
-		err = json.Unmarshal(resultJSON, &s.Result)
-		if err != nil {
-			return nil, err
-		}
+	m, err := job(s)
+	if err != nil {
+		return err
+	}
 
-		err = json.Unmarshal(fileJson, &s.File)
-		if err != nil {
-			return nil, err
-		}
+	err = outboxRepo.Add(tx, m)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
 
-		solutions = append(solutions, &s)
+func updateStatus(q queryer, s *solution.Solution, ev *solution.Event) error {
+	res, err := q.Exec(`
+		UPDATE solutions
+		SET status = $1, updated_at = NOW(), version = version + 1
+		WHERE id = $2 AND version = $3
+	`, ev.To, s.ID, s.Version)
+	if err != nil {
+		return err
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, err
+	err = checkChanged(res, s)
+	if err != nil {
+		return err
 	}
 
-	return solutions, nil
+	return addEvent(q, ev)
 }
 
-func (repo *Pgx) GetByID(id int) (*solution.Solution, error) {
-	row := repo.DB.QueryRow(`
-		SELECT id, user_data, task_id, file, result, status, created_at
-		FROM solutions
-		WHERE id = $1
-	`, id)
+// checkChanged bumps s.Version after a guarded update hit the row.
+func checkChanged(res sql.Result, s *solution.Solution) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return solution.ErrStatusChanged
+	}
+
+	s.Version++
 
+	return nil
+}
+
+func addEvent(q queryer, ev *solution.Event) error {
+	_, err := q.Exec(`
+		INSERT INTO solution_events (solution_id, from_status, to_status, actor, attempt)
+		VALUES ($1, $2, $3, $4, $5)
+	`, ev.SolutionID, ev.From, ev.To, ev.Actor, ev.Attempt)
+
+	return err
+}
+
+const solutionColumns = `id, user_data, task_id, file, result, status, attempt, version, late_seconds, penalty,
+		late_days, created_at, updated_at`
+
+// solutionListColumns are solutionColumns without the inline file bytes.
+const solutionListColumns = `id, user_data, task_id,
+		CASE WHEN jsonb_typeof(file) = 'object' THEN file - 'file' ELSE file END,
+		result, status, attempt, version, late_seconds, penalty, late_days, created_at, updated_at`
+
+type scanner interface {
+	Scan(...interface{}) error
+}
+
+func scanSolution(row scanner) (*solution.Solution, error) {
 	var s solution.Solution
 	var userJSON []byte
 	var resultJSON []byte
 	var fileJson []byte
+	var lateSeconds int64
 
 	err := row.Scan(
 		&s.ID,
@@ -226,15 +503,20 @@ func (repo *Pgx) GetByID(id int) (*solution.Solution, error) {
 		&fileJson,
 		&resultJSON,
 		&s.Status,
+		&s.Attempt,
+		&s.Version,
+		&lateSeconds,
+		&s.Penalty,
+		&s.LateDays,
 		&s.CreatedAt,
+		&s.UpdatedAt,
 	)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, solution.ErrNoSolution
-		}
 		return nil, err
 	}
 
+	s.Late = time.Duration(lateSeconds) * time.Second
+
 	err = json.Unmarshal(userJSON, &s.User)
 	if err != nil {
 		return nil, err
@@ -252,3 +534,273 @@ func (repo *Pgx) GetByID(id int) (*solution.Solution, error) {
 
 	return &s, nil
 }
+
+func (repo *Pgx) query(query string, args ...interface{}) ([]*solution.Solution, error) {
+	return list(repo.DB, query, args...)
+}
+
+func list(q queryer, query string, args ...interface{}) ([]*solution.Solution, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var solutions []*solution.Solution
+
+	for rows.Next() {
+		s, err := scanSolution(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		solutions = append(solutions, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return solutions, nil
+}
+
+// solutionSorts are the sort keys of solution lists.
+var solutionSorts = map[string]string{
+	"id":         "id",
+	"user":       "user_data->>'username'",
+	"status":     "status",
+	"score":      "(result->>'score')::int",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+}
+
+// List returns the page of the solutions the filter lets through and how
+// many it lets through in all. Files are listed by name and hash only,
+// the bytes of old inline ones are left out.
+func (repo *Pgx) List(f solution.Filter, p page.Page) ([]*solution.Solution, int, error) {
+	where := &page.Where{}
+	if f.TaskID != 0 {
+		where.Add("task_id = ?", f.TaskID)
+	}
+	if f.UserIDs != nil {
+		where.Add("user_data->>'id' = ANY(?)", pq.Array(f.UserIDs))
+	}
+	if f.Username != "" {
+		where.Add("user_data->>'username' = ?", f.Username)
+	}
+	if f.Status != "" {
+		where.Add("status = ?", f.Status)
+	}
+	switch f.Verdict {
+	case solution.VerdictPass:
+		where.AddRaw("status IN ('completed', 'failed') AND (result->>'pass')::boolean")
+	case solution.VerdictFail:
+		where.AddRaw("status IN ('completed', 'failed') AND NOT (result->>'pass')::boolean")
+	}
+	if !f.From.IsZero() {
+		where.Add("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		where.Add("created_at < ?", f.To)
+	}
+
+	order, err := p.SQL(solutionSorts, "id")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	err = repo.DB.QueryRow(`SELECT COUNT(*) FROM solutions `+where.String(), where.Args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	solutions, err := repo.query(`
+		SELECT `+solutionListColumns+`
+		FROM solutions
+		`+where.String()+`
+		`+order, where.Args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return solutions, total, nil
+}
+
+// GetListByTaskID returns the page of the solutions of the task, for
+// regrading. Like List it leaves out the bytes of old inline files.
+// Cancelled solutions the user has uploaded a newer one over are
+// superseded and left out.
+func (repo *Pgx) GetListByTaskID(taskID int, p page.Page) ([]*solution.Solution, error) {
+	order, err := p.SQL(solutionSorts, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.query(`
+		SELECT `+solutionListColumns+`
+		FROM solutions s
+		WHERE task_id = $1
+		  AND NOT (status = 'cancelled' AND EXISTS (
+			SELECT 1 FROM solutions n
+			WHERE n.task_id = s.task_id AND n.user_data->>'id' = s.user_data->>'id' AND n.id > s.id
+		  ))
+		`+order, taskID)
+}
+
+func (repo *Pgx) GetByID(id int) (*solution.Solution, error) {
+	row := repo.DB.QueryRow(`
+		SELECT `+solutionColumns+`
+		FROM solutions
+		WHERE id = $1
+	`, id)
+
+	s, err := scanSolution(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, solution.ErrNoSolution
+		}
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// ListStale returns solutions waiting for a result since before the deadline.
+func (repo *Pgx) ListStale(since time.Time) ([]*solution.Solution, error) {
+	return repo.query(`
+		SELECT `+solutionColumns+`
+		FROM solutions
+		WHERE status IN ('queued', 'dispatched', 'running') AND updated_at < $1
+		ORDER BY updated_at
+	`, since)
+}
+
+func addRecovery(q queryer, rec *solution.Recovery) error {
+	_, err := q.Exec(`
+		INSERT INTO solution_recoveries (solution_id, task_id, action, attempt, reason)
+		VALUES ($1, $2, $3, $4, $5)
+	`, rec.SolutionID, rec.TaskID, rec.Action, rec.Attempt, rec.Reason)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Pgx) CountRequeues(solutionID int) (int, error) {
+	var count int
+
+	err := repo.DB.QueryRow(`
+		SELECT COUNT(*)
+		FROM solution_recoveries
+		WHERE solution_id = $1 AND action = $2
+	`, solutionID, solution.RecoveryRequeued).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (repo *Pgx) ListRecoveries(limit int) ([]*solution.Recovery, error) {
+	rows, err := repo.DB.Query(`
+		SELECT id, solution_id, task_id, action, attempt, reason, created_at
+		FROM solution_recoveries
+		ORDER BY id DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recoveries []*solution.Recovery
+
+	for rows.Next() {
+		var rec solution.Recovery
+
+		err = rows.Scan(
+			&rec.ID,
+			&rec.SolutionID,
+			&rec.TaskID,
+			&rec.Action,
+			&rec.Attempt,
+			&rec.Reason,
+			&rec.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		recoveries = append(recoveries, &rec)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return recoveries, nil
+}
+
+// ListEventsBySolutionIDs returns the events of the solutions, oldest
+// first.
+func (repo *Pgx) ListEventsBySolutionIDs(ids []int) ([]*solution.Event, error) {
+	rows, err := repo.DB.Query(`
+		SELECT e.id, e.solution_id, e.from_status, e.to_status, e.actor, e.attempt, e.created_at
+		FROM solution_events e
+		WHERE e.solution_id = ANY($1)
+		ORDER BY e.id
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*solution.Event
+
+	for rows.Next() {
+		var ev solution.Event
+
+		err = rows.Scan(
+			&ev.ID,
+			&ev.SolutionID,
+			&ev.From,
+			&ev.To,
+			&ev.Actor,
+			&ev.Attempt,
+			&ev.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, &ev)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// countInFlight counts the uploads of the user waiting for their first
+// result, as Solution.PendingUpload does, without the queued ones of the
+// task when exceptQueued is set.
+func countInFlight(q queryer, userID string, taskID int, exceptQueued bool) (int, error) {
+	var count int
+
+	err := q.QueryRow(`
+		SELECT COUNT(*)
+		FROM solutions
+		WHERE user_data->>'id' = $1 AND status IN ('queued', 'dispatched', 'running')
+		  AND attempt = 1
+		  AND NOT ($2 AND task_id = $3 AND status = 'queued')
+	`, userID, exceptQueued, taskID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"grader/pkg/queue"
+	outboxService "grader/pkg/server/outbox/service"
+	"grader/pkg/server/solution"
+	"grader/pkg/server/solution/repo"
+	taskService "grader/pkg/server/task/service"
+	"strconv"
+	"time"
+)
+
+// Reaper finds solutions waiting for a result longer than Lease, which
+// happens when a grader dies mid-run. It queues them again up to
+// MaxRetries times and then gives up with an error status.
+type Reaper struct {
+	SolutionRepoPQ repo.SolutionRepoInterface
+	TaskService    taskService.TaskServiceInterface
+	Outbox         outboxService.Notifier
+	Logger         *zap.Logger
+	Lease          time.Duration
+	MaxRetries     int
+	Interval       time.Duration
+}
+
+func NewReaper(pgx repo.SolutionRepoInterface, tasks taskService.TaskServiceInterface, relay outboxService.Notifier, logger *zap.Logger, lease time.Duration, maxRetries int) *Reaper {
+	return &Reaper{
+		SolutionRepoPQ: pgx,
+		TaskService:    tasks,
+		Outbox:         relay,
+		Logger:         logger,
+		Lease:          lease,
+		MaxRetries:     maxRetries,
+		Interval:       time.Minute,
+	}
+}
+
+func (r *Reaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.reap()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Reaper) reap() {
+	stale, err := r.SolutionRepoPQ.ListStale(time.Now().Add(-r.Lease))
+	if err != nil {
+		r.Logger.Error("cant list stale solutions", zap.Error(err))
+		return
+	}
+
+	requeued := false
+
+	for _, s := range stale {
+		rec, err := r.recover(s)
+		if err == solution.ErrStatusChanged {
+			// The result came in meanwhile.
+			continue
+		}
+		if err != nil {
+			r.Logger.Error("cant recover stale solution", zap.Int("solution_id", s.ID), zap.Error(err))
+			continue
+		}
+
+		r.Logger.Warn("stale solution recovered",
+			zap.Int("solution_id", s.ID),
+			zap.String("action", rec.Action),
+			zap.Int("attempt", rec.Attempt),
+		)
+
+		if rec.Action == solution.RecoveryRequeued {
+			requeued = true
+		}
+	}
+
+	if requeued {
+		r.Outbox.Notify()
+	}
+}
+
+func (r *Reaper) recover(s *solution.Solution) (*solution.Recovery, error) {
+	retries, err := r.SolutionRepoPQ.CountRequeues(s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &solution.Recovery{
+		SolutionID: s.ID,
+		TaskID:     s.TaskID,
+	}
+
+	if retries >= r.MaxRetries {
+		rec.Action = solution.RecoveryFailed
+		rec.Attempt = s.Attempt
+		rec.Reason = fmt.Sprintf("no result after %d retries", retries)
+
+		ev, err := newEvent(s, solution.StatusError, solution.ActorServer)
+		if err != nil {
+			return nil, err
+		}
+
+		err = r.SolutionRepoPQ.FailWithRecovery(s, &solution.Result{
+			Pass: false,
+			Text: fmt.Sprintf("Проверка не завершилась после %d попыток, обратитесь к преподавателю", retries+1),
+		}, ev, rec)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		t, err := r.TaskService.GetTaskByID(strconv.Itoa(s.TaskID))
+		if err != nil {
+			return nil, err
+		}
+
+		ev, err := newEvent(s, solution.StatusQueued, solution.ActorServer)
+		if err != nil {
+			return nil, err
+		}
+
+		s.Status = ev.To
+		s.Attempt++
+		ev.Attempt = s.Attempt
+
+		rec.Action = solution.RecoveryRequeued
+		rec.Attempt = s.Attempt
+		rec.Reason = fmt.Sprintf("no result since %s", s.UpdatedAt.Format(time.RFC3339))
+
+		err = r.SolutionRepoPQ.RequeueWithRecovery(s, ev, jobBuilder(context.Background(), t, queue.PriorityNormal), rec)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rec, nil
+}
+
+// Stuck returns solutions the next pass is going to recover.
+func (r *Reaper) Stuck() ([]*solution.Solution, error) {
+	return r.SolutionRepoPQ.ListStale(time.Now().Add(-r.Lease))
+}
+
+func (r *Reaper) Recoveries(limit int) ([]*solution.Recovery, error) {
+	return r.SolutionRepoPQ.ListRecoveries(limit)
+}
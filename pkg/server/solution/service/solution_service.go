@@ -1,111 +1,316 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"grader/pkg/queue"
+	blobRepo "grader/pkg/server/blob/repo"
+	extensionService "grader/pkg/server/extension/service"
+	"grader/pkg/server/outbox"
+	outboxService "grader/pkg/server/outbox/service"
+	"grader/pkg/server/page"
 	"grader/pkg/server/session"
 	"grader/pkg/server/solution"
 	"grader/pkg/server/solution/repo"
+	"grader/pkg/server/task"
+	"grader/pkg/tracing"
 	"mime/multipart"
 	"strconv"
 	"time"
 )
 
 type SolutionServiceInterface interface {
-	UploadSolution(string, *session.Session, []byte, *multipart.FileHeader) (*solution.Solution, error)
-	GetSolutionsByTaskID(string, string, bool) ([]*solution.Solution, error)
+	UploadSolution(context.Context, *task.Task, *session.Session, []byte, *multipart.FileHeader, bool) (*solution.Solution, error)
+	GetSolutions(solution.Filter, page.Page) ([]*solution.Solution, int, error)
 	GetSolutionByID(string) (*solution.Solution, error)
-	GetSolutionsByUserName(string) ([]*solution.Solution, error)
-	UpdateSolution(*solution.Solution) error
+	ApplyResult(*queue.Result) error
+	ApplyStatus(*queue.StatusEvent, string) error
+	CancelSolution(*solution.Solution, string) error
+	RegradeByTaskID(*task.Task) (int, int, error)
+	GetTimelines([]*solution.Solution) (map[int]*solution.Timeline, error)
 }
 
+const statusRetries = 3
+
+// regradeBatch is how many solutions a regrade reads at a time.
+const regradeBatch = 100
+
 type SolutionService struct {
 	SolutionRepoPQ repo.SolutionRepoInterface
+	BlobRepo       blobRepo.BlobRepoInterface
+	Outbox         outboxService.Notifier
+	// Extensions decides how uploads fit the deadlines of their users.
+	Extensions extensionService.ExtensionServiceInterface
+	// MaxInFlight caps solutions waiting for a result per user, 0 means no cap.
+	MaxInFlight int
 }
 
-func NewSolutionService(pgx repo.SolutionRepoInterface) *SolutionService {
+func NewSolutionService(pgx repo.SolutionRepoInterface, blobs blobRepo.BlobRepoInterface, relay outboxService.Notifier, extensions extensionService.ExtensionServiceInterface, maxInFlight int) *SolutionService {
 	return &SolutionService{
 		SolutionRepoPQ: pgx,
+		BlobRepo:       blobs,
+		Outbox:         relay,
+		Extensions:     extensions,
+		MaxInFlight:    maxInFlight,
 	}
 }
 
-func (h *SolutionService) GetSolutionsByUserName(user string) ([]*solution.Solution, error) {
-	var filteredByUser []*solution.Solution
+// jobBuilder makes the grading job message for solutions of the task. The
+// trace in ctx goes along in the message headers.
+func jobBuilder(ctx context.Context, t *task.Task, priority uint8) repo.JobBuilder {
+	return func(s *solution.Solution) (*outbox.Message, error) {
+		caps := t.Capabilities.WithDefaults()
+
+		body, err := json.Marshal(&queue.Job{
+			Version:      queue.JobVersion,
+			SolutionID:   s.ID,
+			TaskID:       s.TaskID,
+			Attempt:      s.Attempt,
+			FileName:     s.File.FileName,
+			ContentHash:  s.File.Hash,
+			SpecVersion:  t.SpecVersion,
+			Capabilities: caps,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		headers := map[string]interface{}{
+			queue.UserIDHeader: s.User.ID,
+		}
+		tracing.InjectHeaders(ctx, headers)
+
+		return &outbox.Message{
+			Exchange:   queue.SolutionExchangeName,
+			RoutingKey: caps.RoutingKey(),
+			Headers:    headers,
+			Priority:   priority,
+			Body:       body,
+		}, nil
+	}
+}
 
-	solutions, err := h.SolutionRepoPQ.List()
+// cancelBuilder makes the message telling dispatchers to skip the solution.
+func cancelBuilder(s *solution.Solution) (*outbox.Message, error) {
+	body, err := json.Marshal(&queue.Cancel{
+		Version:    queue.JobVersion,
+		SolutionID: s.ID,
+		Attempt:    s.Attempt,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	for _, s := range solutions {
-		if s.User.Username != "" && s.User.Username == user {
-			filteredByUser = append(filteredByUser, s)
-		}
+	return &outbox.Message{
+		Exchange: queue.CancelExchangeName,
+		Body:     body,
+	}, nil
+}
+
+// newEvent validates moving s to the status to.
+func newEvent(s *solution.Solution, to, actor string) (*solution.Event, error) {
+	if !solution.CanTransition(s.Status, to) {
+		return nil, solution.ErrBadTransition
 	}
 
-	return filteredByUser, nil
+	return &solution.Event{
+		SolutionID: s.ID,
+		From:       s.Status,
+		To:         to,
+		Actor:      actor,
+		Attempt:    s.Attempt,
+	}, nil
 }
 
-func (h *SolutionService) UploadSolution(taskID string, sess *session.Session, file []byte, fileHeader *multipart.FileHeader) (*solution.Solution, error) {
-	tID, err := strconv.Atoi(taskID)
+// UploadSolution stores an upload and queues it for grading. Staff uploads
+// go first, student ones by how close their own next deadline is.
+func (h *SolutionService) UploadSolution(ctx context.Context, t *task.Task, sess *session.Session, file []byte, fileHeader *multipart.FileHeader, staff bool) (*solution.Solution, error) {
+	now := time.Now()
+
+	admission, err := h.Extensions.Admit(t, sess.User.ID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	priority := queue.Priority(staff, false, admission.Deadline)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("job.priority", int(priority)))
+
+	hash, err := h.BlobRepo.Put(file)
 	if err != nil {
 		return nil, err
 	}
 
 	s := &solution.Solution{
-		TaskID: tID,
+		TaskID: t.ID,
 		User:   sess.User,
 		File: &solution.File{
-			File:     file,
 			FileName: fileHeader.Filename,
+			Hash:     hash,
 		},
-		CreatedAt: time.Now(),
+		CreatedAt: now,
 		Result: &solution.Result{
 			Pass: false,
 			Text: "У вас ошибка в задании",
 		},
-		Status: "pending",
+		Attempt:  1,
+		Late:     admission.Late,
+		Penalty:  admission.Penalty,
+		LateDays: admission.LateDays,
+	}
+
+	ev, err := newEvent(s, solution.StatusQueued, solution.ActorServer)
+	if err != nil {
+		return nil, err
 	}
+	s.Status = ev.To
 
-	s, err = h.SolutionRepoPQ.Add(s)
+	s, err = h.SolutionRepoPQ.AddWithJob(s, ev, jobBuilder(ctx, t, priority), repo.Upload{
+		MaxInFlight: h.MaxInFlight,
+		LatestOnly:  t.Policy.LatestOnly,
+		Cancel:      cancelBuilder,
+		Admission:   admission,
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	h.Outbox.Notify()
+
 	return s, nil
 }
 
-func (h *SolutionService) UpdateSolution(s *solution.Solution) error {
-	err := h.SolutionRepoPQ.Update(s)
+func (h *SolutionService) cancel(s *solution.Solution, actor string) error {
+	ev, err := newEvent(s, solution.StatusCancelled, actor)
 	if err != nil {
 		return err
 	}
 
+	return h.SolutionRepoPQ.UpdateStatusWithJob(s, ev, cancelBuilder)
+}
+
+// CancelSolution stops grading of a solution still waiting for it. Graders
+// see the status on their running report and skip the job.
+func (h *SolutionService) CancelSolution(s *solution.Solution, actor string) error {
+	err := h.cancel(s, actor)
+	if err != nil {
+		return err
+	}
+
+	h.Outbox.Notify()
+
 	return nil
 }
 
-func (h *SolutionService) GetSolutionsByTaskID(taskID string, uID string, isAdmin bool) ([]*solution.Solution, error) {
-	var filteredByUser []*solution.Solution
+// ApplyResult stores the outcome of a grading run. Results for another
+// attempt or for a solution no longer in flight, re-deliveries included,
+// fail with ErrStaleResult and change nothing.
+func (h *SolutionService) ApplyResult(res *queue.Result) error {
+	to := solution.StatusFailed
+	if res.Pass {
+		to = solution.StatusCompleted
+	}
 
-	tID, err := strconv.Atoi(taskID)
-	if err != nil {
-		return nil, err
+	for i := 0; ; i++ {
+		s, err := h.SolutionRepoPQ.GetByID(res.SolutionID)
+		if err != nil {
+			return err
+		}
+
+		if res.Attempt != s.Attempt || !s.InFlight() {
+			return solution.ErrStaleResult
+		}
+
+		ev, err := newEvent(s, to, solution.Actor(solution.ActorGrader, res.Host))
+		if err != nil {
+			return err
+		}
+
+		err = h.SolutionRepoPQ.UpdateResult(s, &solution.Result{
+			Pass:  res.Pass,
+			Text:  res.Text,
+			Score: solution.Score(res.Pass, s.Penalty),
+		}, ev)
+		// A late running report may have moved the solution, read again
+		// and check the attempt once more.
+		if err == solution.ErrStatusChanged && i < statusRetries {
+			continue
+		}
+
+		return err
+	}
+}
+
+// ApplyStatus records progress reported by the queue or a grader, kind
+// names which one.
+func (h *SolutionService) ApplyStatus(st *queue.StatusEvent, kind string) error {
+	if st.Status != solution.StatusDispatched && st.Status != solution.StatusRunning {
+		return solution.ErrBadTransition
 	}
 
-	solutions, err := h.SolutionRepoPQ.GetListByTaskID(tID)
+	for i := 0; ; i++ {
+		s, err := h.SolutionRepoPQ.GetByID(st.SolutionID)
+		if err != nil {
+			return err
+		}
+
+		// Reports from a superseded run make its grader skip the job.
+		if st.Attempt != s.Attempt {
+			return solution.ErrBadTransition
+		}
+
+		ev, err := newEvent(s, st.Status, solution.Actor(kind, st.Host))
+		if err != nil {
+			return err
+		}
+
+		err = h.SolutionRepoPQ.UpdateStatus(s, ev)
+		// The dispatcher and grader reports may race, read again so a
+		// grader isn't told to skip a job that was just dispatched.
+		if err == solution.ErrStatusChanged && i < statusRetries {
+			continue
+		}
+
+		return err
+	}
+}
+
+// GetTimelines returns the timelines of the solutions by solution id.
+func (h *SolutionService) GetTimelines(solutions []*solution.Solution) (map[int]*solution.Timeline, error) {
+	timelines := make(map[int]*solution.Timeline)
+	if len(solutions) == 0 {
+		return timelines, nil
+	}
+
+	ids := make([]int, 0, len(solutions))
+	for _, s := range solutions {
+		ids = append(ids, s.ID)
+	}
+
+	events, err := h.SolutionRepoPQ.ListEventsBySolutionIDs(ids)
 	if err != nil {
 		return nil, err
 	}
 
-	if !isAdmin {
-		for _, s := range solutions {
-			if s.User.ID != "" && s.User.ID == uID {
-				filteredByUser = append(filteredByUser, s)
-			}
+	for _, ev := range events {
+		tl, ok := timelines[ev.SolutionID]
+		if !ok {
+			tl = &solution.Timeline{}
+			timelines[ev.SolutionID] = tl
 		}
-
-		return filteredByUser, nil
+		tl.Events = append(tl.Events, ev)
 	}
 
-	return solutions, nil
+	return timelines, nil
+}
+
+// GetSolutions returns the page of the solutions the filter lets through
+// and how many it lets through in all.
+func (h *SolutionService) GetSolutions(f solution.Filter, p page.Page) ([]*solution.Solution, int, error) {
+	return h.SolutionRepoPQ.List(f, p)
 }
 
 func (h *SolutionService) GetSolutionByID(solutionID string) (*solution.Solution, error) {
@@ -121,3 +326,74 @@ func (h *SolutionService) GetSolutionByID(solutionID string) (*solution.Solution
 
 	return s, nil
 }
+
+// RegradeByTaskID puts every solution of the task back to queued, but
+// those superseded by a newer upload, and queues a bulk priority job for
+// each. A solution that fails doesn't stop
+// the rest: it returns how many were requeued and how many failed, with
+// their errors joined.
+func (h *SolutionService) RegradeByTaskID(t *task.Task) (int, int, error) {
+	requeued := 0
+	var errs []error
+
+	// Solutions are read a page at a time without file bytes, a task can
+	// have many. Requeueing keeps them in the task and in id order, so the
+	// offset still points past the ones done.
+	p := page.Page{Limit: regradeBatch}
+	for {
+		solutions, err := h.SolutionRepoPQ.GetListByTaskID(t.ID, p)
+		if err != nil {
+			return requeued, len(errs), errors.Join(append(errs, err)...)
+		}
+
+		for _, s := range solutions {
+			err = h.regrade(t, s)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("solution %d: %w", s.ID, err))
+				continue
+			}
+
+			requeued++
+		}
+
+		if len(solutions) < p.Limit {
+			break
+		}
+		p.Offset += p.Limit
+	}
+
+	if requeued > 0 {
+		h.Outbox.Notify()
+	}
+
+	return requeued, len(errs), errors.Join(errs...)
+}
+
+func (h *SolutionService) regrade(t *task.Task, s *solution.Solution) error {
+	ev, err := newEvent(s, solution.StatusQueued, solution.ActorServer)
+	if err != nil {
+		return err
+	}
+
+	s.Status = ev.To
+	s.Attempt++
+	ev.Attempt = s.Attempt
+
+	// Move files stored inline before the blob store existed. Lists leave
+	// their bytes out, so the solution is read in full first.
+	if s.File != nil && s.File.Hash == "" {
+		full, err := h.SolutionRepoPQ.GetByID(s.ID)
+		if err != nil {
+			return err
+		}
+		s.File = full.File
+
+		s.File.Hash, err = h.BlobRepo.Put(s.File.File)
+		if err != nil {
+			return err
+		}
+		s.File.File = nil
+	}
+
+	return h.SolutionRepoPQ.UpdateWithJob(s, ev, jobBuilder(context.Background(), t, queue.PriorityRegrade))
+}
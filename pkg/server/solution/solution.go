@@ -2,30 +2,209 @@ package solution
 
 import (
 	"errors"
+	"grader/pkg/queue"
 	"grader/pkg/server/user"
 	"time"
 )
 
+const (
+	StatusQueued     = "queued"
+	StatusDispatched = queue.StatusDispatched
+	StatusRunning    = queue.StatusRunning
+	// StatusCompleted and StatusFailed both carry a grading result, the
+	// solution passed or did not.
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+	// StatusError means the solution could not be graded at all.
+	StatusError = "error"
+)
+
+const (
+	ActorServer = "server"
+	ActorQueue  = "queue"
+	ActorGrader = "grader"
+)
+
+// Actor names who made a transition, host is the machine it ran on.
+func Actor(kind, host string) string {
+	if host == "" {
+		return kind
+	}
+
+	return kind + ":" + host
+}
+
+// transitions lists the statuses reachable from each status, "" is a
+// solution not stored yet. Any status may go back to queued on regrade or
+// recovery; results may overtake the dispatched and running reports.
+var transitions = map[string][]string{
+	"":               {StatusQueued},
+	StatusQueued:     {StatusQueued, StatusDispatched, StatusRunning, StatusCompleted, StatusFailed, StatusCancelled, StatusError},
+	StatusDispatched: {StatusQueued, StatusRunning, StatusCompleted, StatusFailed, StatusCancelled, StatusError},
+	StatusRunning:    {StatusQueued, StatusCompleted, StatusFailed, StatusCancelled, StatusError},
+	StatusCompleted:  {StatusQueued},
+	StatusFailed:     {StatusQueued},
+	StatusCancelled:  {StatusQueued},
+	StatusError:      {StatusQueued},
+}
+
+func CanTransition(from, to string) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+
+	return false
+}
+
 type Solution struct {
-	ID        int
-	User      *user.Claims
-	TaskID    int
-	File      *File
-	Result    *Result
-	Status    string
+	ID     int
+	User   *user.Claims
+	TaskID int
+	File   *File
+	Result *Result
+	Status string
+	// Attempt is the grading run, jobs and results carry it.
+	Attempt int
+	// Version grows with every update, writes expect the one they read.
+	Version int
+	// Late is how long after the task soft deadline it was uploaded and
+	// Penalty the percent of the score it loses for that.
+	Late    time.Duration
+	Penalty int
+	// LateDays spent on the task by the upload's time, they excuse Late.
+	LateDays  int
 	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Event records a status transition of a solution and who made it: the
+// server, the queue or a grader host.
+type Event struct {
+	ID         int
+	SolutionID int
+	From       string
+	To         string
+	Actor      string
+	Attempt    int
+	CreatedAt  time.Time
+}
+
+// Timeline is the history of a solution in the order it happened.
+type Timeline struct {
+	Events []*Event
+}
+
+// Latency is the time from the latest queueing to its result, zero while
+// the solution has no result.
+func (t *Timeline) Latency() time.Duration {
+	var queued, done *Event
+
+	for _, ev := range t.Events {
+		switch ev.To {
+		case StatusQueued:
+			queued, done = ev, nil
+		case StatusCompleted, StatusFailed:
+			done = ev
+		}
+	}
+
+	if queued == nil || done == nil {
+		return 0
+	}
+
+	return done.CreatedAt.Sub(queued.CreatedAt)
+}
+
+// InFlight reports whether the solution still waits for a result.
+func (s *Solution) InFlight() bool {
+	return s.Status == StatusQueued || s.Status == StatusDispatched || s.Status == StatusRunning
+}
+
+// PendingUpload reports whether the solution is an upload still waiting
+// for its first result. Regrades and reaper requeues bump the attempt, they
+// aren't the user's doing and don't count against the in-flight cap.
+func (s *Solution) PendingUpload() bool {
+	return s.InFlight() && s.Attempt == 1
+}
+
+func (s *Solution) IsLate() bool {
+	return s.Late > 0
+}
+
+// Score is what a graded solution earns out of 100 after its late penalty.
+func Score(pass bool, penalty int) int {
+	if !pass {
+		return 0
+	}
+
+	return 100 - penalty
+}
+
+// Finished reports whether Result holds the outcome to show.
+func (s *Solution) Finished() bool {
+	return s.Status == StatusCompleted || s.Status == StatusFailed || s.Status == StatusError
+}
+
+// Verdicts a solution list is filtered by: graded and passed or not.
+const (
+	VerdictPass = "pass"
+	VerdictFail = "fail"
+)
+
+// Filter narrows a solution list, zero fields don't.
+type Filter struct {
+	TaskID int
+	// UserIDs limits the list to the work of these users, nil means
+	// everyone's.
+	UserIDs  []string
+	Username string
+	Status   string
+	Verdict  string
+	// From and To bound the upload time, To excluded.
+	From time.Time
+	To   time.Time
+}
+
+const (
+	RecoveryRequeued = "requeued"
+	RecoveryFailed   = "failed"
+)
+
+// Recovery records the reaper acting on a solution stuck without a result.
+type Recovery struct {
+	ID         int
+	SolutionID int
+	TaskID     int
+	Action     string
+	Attempt    int
+	Reason     string
+	CreatedAt  time.Time
 }
 
+// File bytes live in the blob store under Hash, File is only set for
+// solutions uploaded before the blob store existed.
 type File struct {
 	FileName string `json:"fileName "`
-	File     []byte `json:"file"`
+	Hash     string `json:"hash,omitempty"`
+	File     []byte `json:"file,omitempty"`
 }
 
 type Result struct {
-	Pass bool   `json:"pass"`
-	Text string `json:"text"`
+	Pass  bool   `json:"pass"`
+	Text  string `json:"text"`
+	Score int    `json:"score"`
 }
 
 var (
-	ErrNoSolution = errors.New("No solution found")
+	ErrNoSolution      = errors.New("No solution found")
+	ErrTooManyInFlight = errors.New("too many of your solutions are waiting for grading, wait for their results and upload again")
+	ErrBadTransition   = errors.New("solution status can't change that way")
+	// ErrStatusChanged means someone updated the solution since it was read.
+	ErrStatusChanged = errors.New("solution changed concurrently")
+	// ErrStaleResult is a result for an attempt no longer waited for, a
+	// duplicate or one from a run superseded by a regrade.
+	ErrStaleResult = errors.New("result is for a stale attempt")
 )
@@ -0,0 +1,169 @@
+package solution
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{"", StatusQueued, true},
+		{"", StatusRunning, false},
+		{"", StatusCompleted, false},
+
+		{StatusQueued, StatusQueued, true},
+		{StatusQueued, StatusDispatched, true},
+		{StatusQueued, StatusRunning, true},
+		{StatusQueued, StatusCompleted, true},
+		{StatusQueued, StatusCancelled, true},
+		{StatusQueued, StatusError, true},
+
+		{StatusDispatched, StatusQueued, true},
+		{StatusDispatched, StatusRunning, true},
+		{StatusDispatched, StatusFailed, true},
+		{StatusDispatched, StatusDispatched, false},
+
+		{StatusRunning, StatusCompleted, true},
+		{StatusRunning, StatusCancelled, true},
+		{StatusRunning, StatusDispatched, false},
+		{StatusRunning, StatusRunning, false},
+
+		{StatusCompleted, StatusQueued, true},
+		{StatusCompleted, StatusFailed, false},
+		{StatusCompleted, StatusRunning, false},
+		{StatusFailed, StatusQueued, true},
+		{StatusFailed, StatusCompleted, false},
+		{StatusCancelled, StatusQueued, true},
+		{StatusCancelled, StatusCompleted, false},
+		{StatusError, StatusQueued, true},
+		{StatusError, StatusCancelled, false},
+
+		{"unknown", StatusQueued, false},
+		{StatusQueued, "unknown", false},
+	}
+
+	for _, tt := range tests {
+		got := CanTransition(tt.from, tt.to)
+		if got != tt.want {
+			t.Errorf("CanTransition(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+// Every status is reachable and may be queued again, for regrades.
+func TestTransitionsRequeue(t *testing.T) {
+	for from := range transitions {
+		if !CanTransition(from, StatusQueued) {
+			t.Errorf("%q can't go back to queued", from)
+		}
+	}
+}
+
+func TestTimelineLatency(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ev := func(to string, after time.Duration) *Event {
+		return &Event{To: to, CreatedAt: at.Add(after)}
+	}
+
+	tests := []struct {
+		name   string
+		events []*Event
+		want   time.Duration
+	}{
+		{
+			name: "no result",
+			events: []*Event{
+				ev(StatusQueued, 0),
+				ev(StatusRunning, time.Second),
+			},
+		},
+		{
+			name: "graded",
+			events: []*Event{
+				ev(StatusQueued, 0),
+				ev(StatusRunning, time.Second),
+				ev(StatusCompleted, 5*time.Second),
+			},
+			want: 5 * time.Second,
+		},
+		{
+			name: "regraded, from the latest queueing",
+			events: []*Event{
+				ev(StatusQueued, 0),
+				ev(StatusFailed, 5*time.Second),
+				ev(StatusQueued, time.Minute),
+				ev(StatusCompleted, time.Minute+2*time.Second),
+			},
+			want: 2 * time.Second,
+		},
+		{
+			name: "regrade waiting",
+			events: []*Event{
+				ev(StatusQueued, 0),
+				ev(StatusFailed, 5*time.Second),
+				ev(StatusQueued, time.Minute),
+			},
+		},
+		{
+			name: "cancelled",
+			events: []*Event{
+				ev(StatusQueued, 0),
+				ev(StatusCancelled, time.Second),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got := (&Timeline{Events: tt.events}).Latency()
+		if got != tt.want {
+			t.Errorf("%s: Latency() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		pass    bool
+		penalty int
+		want    int
+	}{
+		{pass: true, want: 100},
+		{pass: true, penalty: 30, want: 70},
+		{pass: true, penalty: 100, want: 0},
+		{pass: false, want: 0},
+		{pass: false, penalty: 30, want: 0},
+	}
+
+	for _, tt := range tests {
+		got := Score(tt.pass, tt.penalty)
+		if got != tt.want {
+			t.Errorf("Score(%v, %d) = %d, want %d", tt.pass, tt.penalty, got, tt.want)
+		}
+	}
+}
+
+func TestPendingUpload(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		attempt int
+		want    bool
+	}{
+		{name: "queued upload", status: StatusQueued, attempt: 1, want: true},
+		{name: "running upload", status: StatusRunning, attempt: 1, want: true},
+		{name: "graded upload", status: StatusCompleted, attempt: 1},
+		{name: "cancelled upload", status: StatusCancelled, attempt: 1},
+		{name: "regrade or requeue", status: StatusQueued, attempt: 2},
+		{name: "running regrade", status: StatusDispatched, attempt: 3},
+	}
+
+	for _, tt := range tests {
+		s := &Solution{Status: tt.status, Attempt: tt.attempt}
+		if got := s.PendingUpload(); got != tt.want {
+			t.Errorf("%s: PendingUpload() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
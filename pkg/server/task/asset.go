@@ -0,0 +1,89 @@
+package task
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Asset is a file uploaded to a task: an image of the statement or an
+// attachment it links to. The bytes are in the blob store under Hash.
+type Asset struct {
+	TaskID      int
+	Name        string
+	ContentType string
+	Size        int64
+	Hash        string
+	// Attachment assets are listed on the task page for download, starter
+	// code, data files, public tests.
+	Attachment bool
+	// Private assets, like tests and the reference solution, are served
+	// to staff only and never listed for download.
+	Private bool
+	// Role says what grading does with the asset, empty for plain files.
+	Role string
+	// Weight is the share of the score a test is worth.
+	Weight    int
+	CreatedAt time.Time
+}
+
+// Roles of an asset in grading. Tests and the reference solution are
+// always private.
+const (
+	AssetTest      = "test"
+	AssetReference = "reference"
+)
+
+// MaxAssetSize is the largest file a task takes.
+const MaxAssetSize = 10 << 20
+
+var (
+	ErrNoAsset  = errors.New("task asset not found")
+	ErrBadAsset = errors.New("bad task asset: the name must be a plain file name and the file at most 10 MiB")
+	ErrBadRole  = errors.New("bad asset role: test, reference or none, only tests have a weight and it is not negative")
+	// ErrTwoReferences means the task has a reference solution already.
+	ErrTwoReferences = errors.New("the task has a reference solution already, remove it first")
+)
+
+// ValidateRole checks the role and the weight of the asset and makes tests
+// and the reference private.
+func (a *Asset) ValidateRole() error {
+	switch a.Role {
+	case "":
+		if a.Weight != 0 {
+			return ErrBadRole
+		}
+	case AssetReference:
+		if a.Weight != 0 {
+			return ErrBadRole
+		}
+		a.Private, a.Attachment = true, false
+	case AssetTest:
+		if a.Weight < 0 {
+			return ErrBadRole
+		}
+		a.Private, a.Attachment = true, false
+	default:
+		return ErrBadRole
+	}
+
+	return nil
+}
+
+// ValidAssetName reports whether the name is a plain file name, statements
+// refer to assets by it.
+func ValidAssetName(name string) bool {
+	return name != "" && len(name) <= 255 && name != "." && name != ".." &&
+		!strings.ContainsAny(name, "/\\?#%\x00")
+}
+
+// Image reports whether the asset is shown inline rather than downloaded.
+// SVG is left out, it may carry scripts.
+func (a *Asset) Image() bool {
+	switch a.ContentType {
+	case "image/png", "image/jpeg", "image/gif", "image/webp":
+		return true
+	}
+
+	return false
+}
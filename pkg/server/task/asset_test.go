@@ -0,0 +1,35 @@
+package task
+
+import "testing"
+
+func TestAssetValidateRole(t *testing.T) {
+	tests := []struct {
+		name        string
+		asset       Asset
+		wantErr     error
+		wantPrivate bool
+	}{
+		{name: "plain", asset: Asset{Attachment: true}},
+		{name: "plain with weight", asset: Asset{Weight: 1}, wantErr: ErrBadRole},
+		{name: "test", asset: Asset{Role: AssetTest, Weight: 3, Attachment: true}, wantPrivate: true},
+		{name: "test with negative weight", asset: Asset{Role: AssetTest, Weight: -1}, wantErr: ErrBadRole},
+		{name: "reference", asset: Asset{Role: AssetReference}, wantPrivate: true},
+		{name: "reference with weight", asset: Asset{Role: AssetReference, Weight: 1}, wantErr: ErrBadRole},
+		{name: "unknown role", asset: Asset{Role: "checker"}, wantErr: ErrBadRole},
+	}
+
+	for _, tt := range tests {
+		a := tt.asset
+		err := a.ValidateRole()
+		if err != tt.wantErr {
+			t.Errorf("%s: ValidateRole() = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && a.Private != tt.wantPrivate {
+			t.Errorf("%s: Private = %v, want %v", tt.name, a.Private, tt.wantPrivate)
+		}
+		if a.Private && a.Attachment {
+			t.Errorf("%s: a private asset is listed for download", tt.name)
+		}
+	}
+}
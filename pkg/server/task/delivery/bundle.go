@@ -0,0 +1,185 @@
+package delivery
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"grader/pkg/bundle"
+	"grader/pkg/queue"
+	"grader/pkg/server/authz"
+	"grader/pkg/server/task"
+	"grader/pkg/utils"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// TaskExport downloads the task as a bundle.
+func (h *TaskHandler) TaskExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	t, err := h.TaskService.GetTaskByID(r.FormValue("id"))
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get task by id", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if t.Deleted() {
+		http.Error(w, task.ErrNoTask.Error(), http.StatusNotFound)
+		return
+	}
+
+	b, err := h.TaskService.ExportTask(t.ID)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error export task", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	data, err := b.Bytes()
+	if err != nil {
+		utils.GetLogger(ctx).Error("error zip task bundle", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeZip(w, bundle.Dir(t.Name, map[string]bool{})+".zip", data)
+}
+
+// CourseExport downloads the bundles of every task of the course, or of
+// the tasks outside courses without one.
+func (h *TaskHandler) CourseExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	d, err := authz.FromContext(ctx)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get authorization from context", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	bundles, err := h.TaskService.ExportCourse(d.Target.CourseID)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error export course", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	err = bundle.WriteCourse(&buf, bundles)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error zip course bundle", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeZip(w, fmt.Sprintf("course-%d.zip", d.Target.CourseID), buf.Bytes())
+}
+
+// TaskImport creates a draft task in the course from an uploaded bundle.
+// API clients asking for JSON get the id of the task, pages its edit page.
+func (h *TaskHandler) TaskImport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	d, err := authz.FromContext(ctx)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get authorization from context", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	b, err := bundleFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	taskID, err := h.TaskService.ImportTask(b, d.Target.CourseID, d.Subject.UserID)
+	if err == task.ErrBadPolicy || err == task.ErrBadAsset || err == queue.ErrBadLimits ||
+		errors.Is(err, bundle.ErrBadBundle) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error import task", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	bundleDone(w, r, taskID, http.StatusCreated)
+}
+
+// TaskSync replaces the statement, the spec and the files of the task with
+// those of an uploaded bundle.
+func (h *TaskHandler) TaskSync(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	d, err := authz.FromContext(ctx)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get authorization from context", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	b, err := bundleFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.TaskService.SyncTask(d.Target.TaskID, b, d.Subject.UserID)
+	if err == task.ErrBadPolicy || err == task.ErrBadAsset || err == queue.ErrBadLimits ||
+		errors.Is(err, bundle.ErrBadBundle) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err == task.ErrArchived {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err == task.ErrNoTask {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error sync task", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	bundleDone(w, r, d.Target.TaskID, http.StatusOK)
+}
+
+func bundleFromForm(r *http.Request) (*bundle.Bundle, error) {
+	file, fileHeader, err := r.FormFile("bundle")
+	if err != nil {
+		return nil, fmt.Errorf("%w: no bundle file", bundle.ErrBadBundle)
+	}
+	defer file.Close()
+
+	return bundle.Read(file, fileHeader.Size)
+}
+
+func bundleDone(w http.ResponseWriter, r *http.Request, taskID, status int) {
+	if !strings.Contains(r.Header.Get("Accept"), "application/json") {
+		url := fmt.Sprintf("/tasks/admin/task/%d/edit", taskID)
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		ID int `json:"id"`
+	}{
+		ID: taskID,
+	})
+}
+
+func writeZip(w http.ResponseWriter, name string, data []byte) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Write(data)
+}
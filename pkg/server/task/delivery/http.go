@@ -1,9 +1,19 @@
 package delivery
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
+	"grader/pkg/markdown"
+	"grader/pkg/queue"
+	"grader/pkg/server/authz"
+	authzService "grader/pkg/server/authz/service"
+	"grader/pkg/server/course"
+	courseService "grader/pkg/server/course/service"
+	"grader/pkg/server/extension"
+	extensionService "grader/pkg/server/extension/service"
+	"grader/pkg/server/page"
 	"grader/pkg/server/session"
 	"grader/pkg/server/solution"
 	solutionService "grader/pkg/server/solution/service"
@@ -13,14 +23,23 @@ import (
 	userService "grader/pkg/server/user/service"
 	"grader/pkg/utils"
 	"html/template"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
 )
 
 type TaskHandler struct {
-	Tmpl            *template.Template
-	TaskService     service.TaskServiceInterface
-	SolutionService solutionService.SolutionServiceInterface
-	UserService     userService.UserServiceInterface
+	Tmpl             *template.Template
+	TaskService      service.TaskServiceInterface
+	SolutionService  solutionService.SolutionServiceInterface
+	ExtensionService extensionService.ExtensionServiceInterface
+	CourseService    courseService.CourseServiceInterface
+	UserService      userService.UserServiceInterface
+	Policy           authzService.PolicyInterface
 }
 
 type TaskData struct {
@@ -28,11 +47,125 @@ type TaskData struct {
 	Task      *task.Task
 	Solutions []*solution.Solution
 	Solution  *solution.Solution
+	// Extension is the one of the user looking at the task.
+	Extension *extension.Extension
+	// Timelines by solution id and the Extensions granted, filled on admin
+	// pages only.
+	Timelines  map[int]*solution.Timeline
+	Extensions []*extension.Extension
+	// Staff is set for those who review the solutions of others.
+	Staff bool
+	// Attachments are the files of the task to download.
+	Attachments []*task.Asset
+	// Pager pages Solutions.
+	Pager *page.Pager
 }
 
 type TasksData struct {
-	User  *user.Claims
-	Tasks []*task.Task
+	User    *user.Claims
+	Tasks   []*task.Task
+	Courses []*CourseTasks
+	// Trash lists deleted tasks.
+	Trash bool
+	// Pager and AllCourses, for the course filter, are set on the admin
+	// list only.
+	Pager      *page.Pager
+	AllCourses []*course.Course
+}
+
+// CourseTasks are the tasks of one course a user sees, Course is nil for
+// the tasks outside courses.
+type CourseTasks struct {
+	Course       *course.Course
+	Tasks        []*task.Task
+	LateDaysLeft int
+}
+
+func courseFromForm(r *http.Request) (int, error) {
+	v := r.FormValue("course_id")
+	if v == "" {
+		return 0, nil
+	}
+
+	return strconv.Atoi(v)
+}
+
+// coursesFor returns the courses a task may be put in: every course under
+// a global role, otherwise only the one the decision was made for.
+func (h *TaskHandler) coursesFor(d *authz.Decision, courseID int) ([]*course.Course, error) {
+	if d.Scope == authz.ScopeGlobal {
+		return h.CourseService.GetCourseList()
+	}
+	if courseID == 0 {
+		return nil, nil
+	}
+
+	c, err := h.CourseService.GetCourseByID(strconv.Itoa(courseID))
+	if err != nil {
+		return nil, err
+	}
+
+	return []*course.Course{c}, nil
+}
+
+// taskListURL is where task staff go after saving a task, only global
+// roles see the list of every task.
+func taskListURL(d *authz.Decision) string {
+	if d.Scope == authz.ScopeGlobal {
+		return "/tasks/admin/task/all"
+	}
+
+	return fmt.Sprintf("/tasks/user/%s", d.Subject.Username)
+}
+
+func capabilitiesFromForm(r *http.Request) (queue.Capabilities, error) {
+	c := queue.Capabilities{
+		Language:    r.FormValue("language"),
+		Image:       r.FormValue("image"),
+		Network:     r.FormValue("network") == "on",
+		LargeMemory: r.FormValue("large_memory") == "on",
+	}
+
+	var err error
+	if v := r.FormValue("time_limit"); v != "" {
+		c.Limits.TimeLimit, err = strconv.Atoi(v)
+		if err != nil {
+			return c, queue.ErrBadLimits
+		}
+	}
+	if v := r.FormValue("memory_limit"); v != "" {
+		c.Limits.MemoryLimit, err = strconv.Atoi(v)
+		if err != nil {
+			return c, queue.ErrBadLimits
+		}
+	}
+
+	return c, nil
+}
+
+func policyFromForm(r *http.Request) (task.Policy, error) {
+	p := task.Policy{
+		LatestOnly: r.FormValue("latest_only") == "on",
+		LatePolicy: r.FormValue("late_policy"),
+	}
+
+	var err error
+	p.SoftDeadline, err = task.ParseDeadline(r.FormValue("soft_deadline"))
+	if err != nil {
+		return p, task.ErrBadPolicy
+	}
+	p.HardDeadline, err = task.ParseDeadline(r.FormValue("hard_deadline"))
+	if err != nil {
+		return p, task.ErrBadPolicy
+	}
+	if v := r.FormValue("penalty"); v != "" {
+		p.Penalty, err = strconv.Atoi(v)
+		if err != nil {
+			return p, task.ErrBadPolicy
+		}
+	}
+
+	return p, nil
 }
 
 func (h *TaskHandler) TaskCreate(w http.ResponseWriter, r *http.Request) {
@@ -45,24 +178,32 @@ func (h *TaskHandler) TaskCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	u, err := h.UserService.UserByID(sess.User.ID)
+	d, err := authz.FromContext(ctx)
 	if err != nil {
-		utils.GetLogger(ctx).Error("error get user by id", zap.Error(err))
+		utils.GetLogger(ctx).Error("error get authorization from context", zap.Error(err))
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	if !u.Admin {
-		url := fmt.Sprintf("/tasks/user/%s", u.Username)
-		http.Redirect(w, r, url, http.StatusFound)
+	courses, err := h.coursesFor(d, d.Target.CourseID)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get course list", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
 	err = h.Tmpl.ExecuteTemplate(w, "task_create.html",
 		struct {
-			User *user.Claims
+			User    *user.Claims
+			Courses []*course.Course
+			// AnyCourse allows tasks outside courses.
+			AnyCourse bool
+			CourseID  int
 		}{
-			User: sess.User,
+			User:      sess.User,
+			Courses:   courses,
+			AnyCourse: d.Scope == authz.ScopeGlobal,
+			CourseID:  d.Target.CourseID,
 		})
 
 	if err != nil {
@@ -84,35 +225,58 @@ func (h *TaskHandler) TaskEdit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	u, err := h.UserService.UserByID(sess.User.ID)
+	t, err = h.TaskService.GetTaskByID(taskID)
 	if err != nil {
-		utils.GetLogger(ctx).Error("error get user by id", zap.Error(err))
+		utils.GetLogger(ctx).Error("error get task by id", zap.Error(err))
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	if !u.Admin {
-		url := fmt.Sprintf("/tasks/user/%s", u.Username)
-		http.Redirect(w, r, url, http.StatusFound)
+	d, err := authz.FromContext(ctx)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get authorization from context", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	t, err = h.TaskService.GetTaskByID(taskID)
+	courses, err := h.coursesFor(d, t.CourseID)
 	if err != nil {
-		utils.GetLogger(ctx).Error("error get task by id", zap.Error(err))
+		utils.GetLogger(ctx).Error("error get course list", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	staff, err := h.TaskService.GetStaff(t.ID)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get task staff", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	assets, err := h.TaskService.GetAssets(t.ID)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get task assets", zap.Error(err))
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
 	err = h.Tmpl.ExecuteTemplate(w, "task_edit.html",
 		struct {
-			User *user.Claims
-			Task *task.Task
-			URL  string
+			User      *user.Claims
+			Task      *task.Task
+			Courses   []*course.Course
+			AnyCourse bool
+			Staff     []*task.Staff
+			Assets    []*task.Asset
+			URL       string
 		}{
-			User: sess.User,
-			Task: t,
-			URL:  r.URL.String(),
+			User:      sess.User,
+			Task:      t,
+			Courses:   courses,
+			AnyCourse: d.Scope == authz.ScopeGlobal,
+			Staff:     staff,
+			Assets:    assets,
+			URL:       r.URL.String(),
 		})
 
 	if err != nil {
@@ -131,35 +295,38 @@ func (h *TaskHandler) TaskAdd(w http.ResponseWriter, r *http.Request) {
 	name := r.FormValue("name")
 	description := r.FormValue("description")
 
-	sess, err := session.SessionFromContext(ctx)
+	d, err := authz.FromContext(ctx)
 	if err != nil {
-		utils.GetLogger(ctx).Error("error get session from context", zap.Error(err))
+		utils.GetLogger(ctx).Error("error get authorization from context", zap.Error(err))
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	u, err := h.UserService.UserByID(sess.User.ID)
+	courseID, err := courseFromForm(r)
 	if err != nil {
-		utils.GetLogger(ctx).Error("error get user by id", zap.Error(err))
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		http.Error(w, "bad course", http.StatusBadRequest)
 		return
 	}
 
-	if !u.Admin {
-		utils.GetLogger(ctx).Error("User not admin")
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	policy, err := policyFromForm(r)
+	var caps queue.Capabilities
+	if err == nil {
+		caps, err = capabilitiesFromForm(r)
+	}
+	if err == nil {
+		err = h.TaskService.CreateTask(name, description, courseID, caps, policy, d.Subject.UserID)
+	}
+	if err == task.ErrBadPolicy || err == queue.ErrBadLimits {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	err = h.TaskService.CreateTask(name, description)
 	if err != nil {
 		utils.GetLogger(ctx).Error("error create task", zap.Error(err))
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	url := fmt.Sprintf("/tasks/admin/task/all")
-	http.Redirect(w, r, url, http.StatusFound)
+	http.Redirect(w, r, taskListURL(d), http.StatusFound)
 }
 
 func (h *TaskHandler) TaskUpdate(w http.ResponseWriter, r *http.Request) {
@@ -172,40 +339,66 @@ func (h *TaskHandler) TaskUpdate(w http.ResponseWriter, r *http.Request) {
 	description := r.FormValue("description")
 	taskID := r.FormValue("id")
 
-	sess, err := session.SessionFromContext(ctx)
+	d, err := authz.FromContext(ctx)
 	if err != nil {
-		utils.GetLogger(ctx).Error("error get session from context", zap.Error(err))
+		utils.GetLogger(ctx).Error("error get authorization from context", zap.Error(err))
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	u, err := h.UserService.UserByID(sess.User.ID)
+	courseID, err := courseFromForm(r)
 	if err != nil {
-		utils.GetLogger(ctx).Error("error get user by id", zap.Error(err))
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		http.Error(w, "bad course", http.StatusBadRequest)
 		return
 	}
 
-	if !u.Admin {
-		utils.GetLogger(ctx).Error("User not admin", zap.Error(err))
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+	// Moving the task needs the right to manage tasks where it goes too.
+	if courseID != d.Target.CourseID {
+		_, err = h.Policy.Authorize(d.Subject, authz.ManageTasks, authz.Target{CourseID: courseID})
+		if err == authz.ErrForbidden {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			utils.GetLogger(ctx).Error("error authorize task move", zap.Error(err))
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
 	}
 
-	err = h.TaskService.UpdateTask(name, description, taskID)
+	policy, err := policyFromForm(r)
+	var caps queue.Capabilities
+	if err == nil {
+		caps, err = capabilitiesFromForm(r)
+	}
+	if err == nil {
+		err = h.TaskService.UpdateTask(name, description, taskID, courseID, caps, policy, d.Subject.UserID)
+	}
+	if err == task.ErrBadPolicy || err == queue.ErrBadLimits {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err == task.ErrArchived {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
-		utils.GetLogger(ctx).Error("error create task", zap.Error(err))
+		utils.GetLogger(ctx).Error("error update task", zap.Error(err))
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	url := fmt.Sprintf("/tasks/admin/task/all")
-	http.Redirect(w, r, url, http.StatusFound)
+	http.Redirect(w, r, taskListURL(d), http.StatusFound)
 }
 
+// TaskList shows a page of the tasks, filtered and sorted by the query,
+// see taskFilter and page.FromRequest.
 func (h *TaskHandler) TaskList(w http.ResponseWriter, r *http.Request) {
+	h.taskList(w, r, &TasksData{})
+}
+
+func (h *TaskHandler) taskList(w http.ResponseWriter, r *http.Request, data *TasksData) {
 	ctx := r.Context()
-	data := &TasksData{}
 
 	sess, err := session.SessionFromContext(ctx)
 	if err != nil {
@@ -214,29 +407,40 @@ func (h *TaskHandler) TaskList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	u, err := h.UserService.UserByID(sess.User.ID)
+	data.User = sess.User
+
+	f, err := taskFilter(r)
 	if err != nil {
-		utils.GetLogger(ctx).Error("error get user by id", zap.Error(err))
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	data.User = sess.User
+	f.Deleted = data.Trash
 
-	if !u.Admin {
-		url := fmt.Sprintf("/tasks/user/%s", u.Username)
-		http.Redirect(w, r, url, http.StatusFound)
+	p, err := page.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	//TODO added with query params limit offset
-
-	t, err := h.TaskService.GetTaskList()
+	t, total, err := h.TaskService.GetTaskList(f, p)
+	if err == page.ErrBadPage {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		utils.GetLogger(ctx).Error("error get task list", zap.Error(err))
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 	data.Tasks = t
+	data.Pager = page.NewPager(r, p, total)
+
+	data.AllCourses, err = h.CourseService.GetCourseList()
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get courses", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
 
 	err = h.Tmpl.ExecuteTemplate(w, "task_list.html", data)
 	if err != nil {
@@ -266,20 +470,45 @@ func (h *TaskHandler) TasksByUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	solutions, err := h.SolutionService.GetSolutionsByUserName(uName)
+	tasks, err := h.TaskService.GetTasksForUser(sess.User.ID)
 	if err != nil {
-		utils.GetLogger(ctx).Error("error get solutions", zap.Error(err))
+		utils.GetLogger(ctx).Error("error get tasks", zap.Error(err))
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	tasks, err := h.TaskService.GetTasksByUserSolutions(solutions)
+	courses, err := h.CourseService.GetCoursesByUser(sess.User.ID)
 	if err != nil {
-		utils.GetLogger(ctx).Error("error get tasks", zap.Error(err))
+		utils.GetLogger(ctx).Error("error get courses", zap.Error(err))
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
+	byCourse := map[int]*CourseTasks{0: {}}
+	for _, c := range courses {
+		byCourse[c.ID] = &CourseTasks{Course: c}
+		data.Courses = append(data.Courses, byCourse[c.ID])
+	}
+	data.Courses = append(data.Courses, byCourse[0])
+
+	for _, t := range tasks {
+		// Tasks of courses the user only is task staff of go with the
+		// tasks outside courses.
+		ct, ok := byCourse[t.CourseID]
+		if !ok {
+			ct = byCourse[0]
+		}
+		ct.Tasks = append(ct.Tasks, t)
+	}
+
+	for id, ct := range byCourse {
+		ct.LateDaysLeft, err = h.ExtensionService.LateDaysLeft(sess.User.ID, id)
+		if err != nil {
+			utils.GetLogger(ctx).Error("error get late days", zap.Error(err))
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
 	data.Tasks = tasks
 
 	err = h.Tmpl.ExecuteTemplate(w, "tasks_by_user.html", data)
@@ -318,32 +547,61 @@ func (h *TaskHandler) TaskByID(w http.ResponseWriter, r *http.Request) {
 	}
 	data.Task = t
 
-	u, err := h.UserService.UserByID(sess.User.ID)
+	d, err := authz.FromContext(ctx)
 	if err != nil {
-		utils.GetLogger(ctx).Error("error get user by id", zap.Error(err))
+		utils.GetLogger(ctx).Error("error get authorization from context", zap.Error(err))
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	solutions, err := h.SolutionService.GetSolutionsByTaskID(taskID, sess.User.ID, u.Admin)
+	if t.Deleted() || !d.Staff() && !t.Live() {
+		url := fmt.Sprintf("/tasks/user/%s", sess.User.Username)
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+
+	f, err := solutionFilter(r, t.ID, d)
 	if err != nil {
-		if err == task.ErrNoTask {
-			utils.GetLogger(ctx).Error("Solutions not found", zap.Error(err))
-		} else {
-			utils.GetLogger(ctx).Error("Error get solutions", zap.Error(err))
-			http.Error(w, "internal error", http.StatusInternalServerError)
-		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p, err := page.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	solutions, total, err := h.SolutionService.GetSolutions(f, p)
+	if err == page.ErrBadPage {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("Error get solutions", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 	data.Solutions = solutions
+	data.Pager = page.NewPager(r, p, total)
+	data.Staff = d.Staff()
+
+	data.Attachments, err = h.TaskService.GetAttachments(t.ID)
+	if err != nil {
+		utils.GetLogger(ctx).Error("Error get attachments", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	data.Extension, err = h.ExtensionService.GetExtension(sess.User.ID, t.ID)
+	if err != nil {
+		utils.GetLogger(ctx).Error("Error get extension", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
 
 	if solutionID != "" {
 		s, err = h.SolutionService.GetSolutionByID(solutionID)
-		if sess.User.ID != s.User.ID {
-			url := fmt.Sprintf("/tasks/%s", taskID)
-			http.Redirect(w, r, url, http.StatusFound)
-			return
-		}
 		if err != nil {
 			if err == solution.ErrNoSolution {
 				utils.GetLogger(ctx).Error("Solution not found", zap.Error(err))
@@ -353,6 +611,11 @@ func (h *TaskHandler) TaskByID(w http.ResponseWriter, r *http.Request) {
 			}
 			return
 		}
+		if !d.Covers(s.User.ID) || s.TaskID != t.ID {
+			url := fmt.Sprintf("/tasks/%s", taskID)
+			http.Redirect(w, r, url, http.StatusFound)
+			return
+		}
 
 		data.Solution = s
 	}
@@ -377,19 +640,6 @@ func (h *TaskHandler) TaskSolutions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	u, err := h.UserService.UserByID(sess.User.ID)
-	if err != nil {
-		utils.GetLogger(ctx).Error("error get user by id", zap.Error(err))
-		http.Error(w, "internal error", http.StatusInternalServerError)
-		return
-	}
-
-	if !u.Admin {
-		url := fmt.Sprintf("/tasks/user/%s", u.Username)
-		http.Redirect(w, r, url, http.StatusFound)
-		return
-	}
-
 	data.User = sess.User
 
 	t, err := h.TaskService.GetTaskByID(taskID)
@@ -404,17 +654,66 @@ func (h *TaskHandler) TaskSolutions(w http.ResponseWriter, r *http.Request) {
 	}
 	data.Task = t
 
-	solutions, err := h.SolutionService.GetSolutionsByTaskID(taskID, sess.User.ID, u.Admin)
+	d, err := authz.FromContext(ctx)
 	if err != nil {
-		if err == task.ErrNoTask {
-			utils.GetLogger(ctx).Error("Solutions not found", zap.Error(err))
-		} else {
-			utils.GetLogger(ctx).Error("Error get solutions", zap.Error(err))
-			http.Error(w, "internal error", http.StatusInternalServerError)
-		}
+		utils.GetLogger(ctx).Error("error get authorization from context", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	f, err := solutionFilter(r, t.ID, d)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p, err := page.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	solutions, total, err := h.SolutionService.GetSolutions(f, p)
+	if err == page.ErrBadPage {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("Error get solutions", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 	data.Solutions = solutions
+	data.Pager = page.NewPager(r, p, total)
+
+	data.Timelines, err = h.SolutionService.GetTimelines(solutions)
+	if err != nil {
+		utils.GetLogger(ctx).Error("Error get solution timelines", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	// Only the extensions of the students on the page.
+	seen := make(map[string]bool)
+	var userIDs []string
+	for _, s := range solutions {
+		if !seen[s.User.ID] {
+			seen[s.User.ID] = true
+			userIDs = append(userIDs, s.User.ID)
+		}
+	}
+
+	extensions, err := h.ExtensionService.GetExtensionsByTaskUsers(t.ID, userIDs)
+	if err != nil {
+		utils.GetLogger(ctx).Error("Error get extensions", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	for _, e := range extensions {
+		if d.Covers(e.UserID) {
+			data.Extensions = append(data.Extensions, e)
+		}
+	}
 
 	err = h.Tmpl.ExecuteTemplate(w, "task_solutions.html", data)
 	if err != nil {
@@ -423,3 +722,479 @@ func (h *TaskHandler) TaskSolutions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// StaffAdd gives a user a role on one task, see task.Staff.
+func (h *TaskHandler) StaffAdd(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	tID, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid task id", http.StatusBadRequest)
+		return
+	}
+
+	u, err := h.UserService.UserByName(r.FormValue("username"))
+	if err == user.ErrNoUser {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get user by name", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.TaskService.AddStaff(tID, u.ID, r.FormValue("role"))
+	if err == task.ErrBadStaff {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error add task staff", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	url := fmt.Sprintf("/tasks/admin/task/%d/edit", tID)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func (h *TaskHandler) StaffRemove(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	tID, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid task id", http.StatusBadRequest)
+		return
+	}
+
+	err = h.TaskService.RemoveStaff(tID, r.FormValue("user_id"))
+	if err != nil {
+		utils.GetLogger(ctx).Error("error remove task staff", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	url := fmt.Sprintf("/tasks/admin/task/%d/edit", tID)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// TaskState publishes, schedules, unpublishes or archives a task.
+func (h *TaskHandler) TaskState(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	taskID := r.FormValue("id")
+
+	publishAt, err := task.ParseDeadline(r.FormValue("publish_at"))
+	if err == nil {
+		err = h.TaskService.SetTaskState(taskID, r.FormValue("state"), publishAt)
+	}
+	if err == task.ErrBadState {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err == task.ErrNoTask {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error set task state", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	url := fmt.Sprintf("/tasks/admin/task/%s/edit", taskID)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// TaskDelete moves a task to the trash.
+func (h *TaskHandler) TaskDelete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	d, err := authz.FromContext(ctx)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get authorization from context", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.TaskService.DeleteTask(r.FormValue("id"))
+	if err == task.ErrNoTask {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error delete task", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, taskListURL(d), http.StatusFound)
+}
+
+// TaskRestore takes a task out of the trash in the state it was deleted in.
+func (h *TaskHandler) TaskRestore(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	taskID := r.FormValue("id")
+
+	err := h.TaskService.RestoreTask(taskID)
+	if err == task.ErrNoTask {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error restore task", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	url := fmt.Sprintf("/tasks/admin/task/%s/edit", taskID)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// TaskTrash shows the deleted tasks, filtered as TaskList is.
+func (h *TaskHandler) TaskTrash(w http.ResponseWriter, r *http.Request) {
+	h.taskList(w, r, &TasksData{Trash: true})
+}
+
+type HistoryData struct {
+	User      *user.Claims
+	Task      *task.Task
+	Revisions []*task.Revision
+	// Diff is of the revision picked by ?rev= against ?from=, the one
+	// before it by default.
+	Diff *task.Diff
+}
+
+func (h *TaskHandler) TaskHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	taskID := chi.URLParam(r, "id")
+	data := &HistoryData{}
+
+	sess, err := session.SessionFromContext(ctx)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get session from context", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	data.User = sess.User
+
+	data.Task, err = h.TaskService.GetTaskByID(taskID)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get task by id", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	data.Revisions, err = h.TaskService.GetRevisions(taskID)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get task revisions", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if v := r.URL.Query().Get("rev"); v != "" {
+		number, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid revision", http.StatusBadRequest)
+			return
+		}
+
+		from := number - 1
+		if v := r.URL.Query().Get("from"); v != "" {
+			from, err = strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "Invalid revision", http.StatusBadRequest)
+				return
+			}
+		}
+
+		to := findRevision(data.Revisions, number)
+		if to == nil {
+			http.Error(w, task.ErrNoRevision.Error(), http.StatusNotFound)
+			return
+		}
+		data.Diff = task.Compare(findRevision(data.Revisions, from), to)
+	}
+
+	err = h.Tmpl.ExecuteTemplate(w, "task_history.html", data)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error execute template", zap.Error(err))
+		http.Error(w, `Template error`, http.StatusInternalServerError)
+		return
+	}
+}
+
+func findRevision(revisions []*task.Revision, number int) *task.Revision {
+	for _, rev := range revisions {
+		if rev.Number == number {
+			return rev
+		}
+	}
+
+	return nil
+}
+
+// TaskRollback brings back an earlier revision of the task.
+func (h *TaskHandler) TaskRollback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	taskID := r.FormValue("id")
+
+	d, err := authz.FromContext(ctx)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get authorization from context", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	number, err := strconv.Atoi(r.FormValue("revision"))
+	if err != nil {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	err = h.TaskService.RollbackTask(taskID, number, d.Subject.UserID)
+	if err == task.ErrNoTask || err == task.ErrNoRevision {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err == task.ErrArchived {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error roll back task", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	url := fmt.Sprintf("/tasks/admin/task/%s/history", taskID)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// TaskAsset serves a file of the task to those who see the task. Assets
+// are sandboxed, an uploaded page can't act as the site.
+func (h *TaskHandler) TaskAsset(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	name, err := url.PathUnescape(chi.URLParam(r, "name"))
+	if err != nil {
+		http.Error(w, task.ErrNoAsset.Error(), http.StatusNotFound)
+		return
+	}
+
+	d, err := authz.FromContext(ctx)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get authorization from context", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	t, err := h.TaskService.GetTaskByID(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get task by id", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if t.Deleted() || !d.Staff() && !t.Live() {
+		http.Error(w, task.ErrNoTask.Error(), http.StatusNotFound)
+		return
+	}
+
+	a, data, err := h.TaskService.GetAsset(t.ID, name)
+	if err == nil && a.Private && !d.Staff() {
+		err = task.ErrNoAsset
+	}
+	if err == task.ErrNoAsset {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get task asset", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	if !a.Image() {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+	}
+	w.Write(data)
+}
+
+// AssetUpload adds a file to the task under its own name or the one given.
+func (h *TaskHandler) AssetUpload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	taskID := r.FormValue("id")
+
+	file, fileHeader, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error read asset", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	name := r.FormValue("name")
+	if name == "" {
+		name = path.Base(strings.ReplaceAll(fileHeader.Filename, "\\", "/"))
+	}
+
+	t, err := h.TaskService.GetTaskByID(taskID)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get task by id", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if t.Archived() {
+		http.Error(w, task.ErrArchived.Error(), http.StatusForbidden)
+		return
+	}
+
+	weight := 0
+	if v := r.FormValue("weight"); v != "" {
+		weight, err = strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, task.ErrBadRole.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = h.TaskService.AddAsset(&task.Asset{
+		TaskID:     t.ID,
+		Name:       name,
+		Attachment: r.FormValue("attachment") == "on",
+		Private:    r.FormValue("private") == "on",
+		Role:       r.FormValue("role"),
+		Weight:     weight,
+	}, data)
+	if err == task.ErrBadAsset || err == task.ErrBadRole || err == task.ErrTwoReferences {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error add task asset", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	url := fmt.Sprintf("/tasks/admin/task/%d/edit", t.ID)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func (h *TaskHandler) AssetRemove(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	t, err := h.TaskService.GetTaskByID(r.FormValue("id"))
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get task by id", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if t.Archived() {
+		http.Error(w, task.ErrArchived.Error(), http.StatusForbidden)
+		return
+	}
+
+	err = h.TaskService.RemoveAsset(t.ID, r.FormValue("name"))
+	if err == task.ErrNoAsset {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error remove task asset", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	url := fmt.Sprintf("/tasks/admin/task/%d/edit", t.ID)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// AssetAttachment lists an asset for download on the task page or takes
+// it off the list.
+func (h *TaskHandler) AssetAttachment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	t, err := h.TaskService.GetTaskByID(r.FormValue("id"))
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get task by id", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if t.Archived() {
+		http.Error(w, task.ErrArchived.Error(), http.StatusForbidden)
+		return
+	}
+
+	err = h.TaskService.SetAttachment(t.ID, r.FormValue("name"), r.FormValue("attachment") == "on")
+	if err == task.ErrNoAsset {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error set task attachment", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	url := fmt.Sprintf("/tasks/admin/task/%d/edit", t.ID)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// TaskAttachments downloads every attachment of the task as one zip, to
+// those who see the task.
+func (h *TaskHandler) TaskAttachments(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	d, err := authz.FromContext(ctx)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get authorization from context", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	t, err := h.TaskService.GetTaskByID(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get task by id", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if t.Deleted() || !d.Staff() && !t.Live() {
+		http.Error(w, task.ErrNoTask.Error(), http.StatusNotFound)
+		return
+	}
+
+	var buf bytes.Buffer
+	err = h.TaskService.WriteAttachments(t.ID, &buf)
+	if err == task.ErrNoAsset {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error zip task attachments", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeZip(w, fmt.Sprintf("task-%d.zip", t.ID), buf.Bytes())
+}
+
+// Preview renders a statement the way the task page will, for the staff
+// editing the task or creating one in the course.
+func (h *TaskHandler) Preview(w http.ResponseWriter, r *http.Request) {
+	base := ""
+	if id, err := strconv.Atoi(r.FormValue("id")); err == nil {
+		base = fmt.Sprintf("/tasks/%d/assets/", id)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, string(markdown.Render(r.FormValue("description"), base)))
+}
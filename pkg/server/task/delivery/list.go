@@ -0,0 +1,146 @@
+package delivery
+
+import (
+	"go.uber.org/zap"
+	"grader/pkg/server/authz"
+	"grader/pkg/server/page"
+	"grader/pkg/server/solution"
+	"grader/pkg/server/task"
+	"grader/pkg/utils"
+	"net/http"
+	"strconv"
+)
+
+// taskFilter reads a task list filter from the query: course_id, a course
+// or none for the tasks outside courses, state, q, part of the name, and
+// the creation dates from and to.
+func taskFilter(r *http.Request) (task.Filter, error) {
+	f := task.Filter{
+		State: r.FormValue("state"),
+		Query: r.FormValue("q"),
+	}
+
+	if f.State != "" && !task.ValidState(f.State) {
+		return task.Filter{}, page.ErrBadFilter
+	}
+
+	switch v := r.FormValue("course_id"); v {
+	case "":
+	case "none":
+		f.NoCourse = true
+	default:
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			return task.Filter{}, page.ErrBadFilter
+		}
+		f.CourseID = id
+	}
+
+	var err error
+	f.From, f.To, err = page.DateRange(r)
+	if err != nil {
+		return task.Filter{}, err
+	}
+
+	return f, nil
+}
+
+// solutionFilter reads a solution list filter of the task from the query:
+// user, a username, status, verdict, pass or fail, and the upload dates
+// from and to. Only the work of the users d covers is listed.
+func solutionFilter(r *http.Request, taskID int, d *authz.Decision) (solution.Filter, error) {
+	f := solution.Filter{
+		TaskID:   taskID,
+		UserIDs:  d.Users(),
+		Username: r.FormValue("user"),
+		Status:   r.FormValue("status"),
+		Verdict:  r.FormValue("verdict"),
+	}
+
+	if f.Verdict != "" && f.Verdict != solution.VerdictPass && f.Verdict != solution.VerdictFail {
+		return solution.Filter{}, page.ErrBadFilter
+	}
+
+	var err error
+	f.From, f.To, err = page.DateRange(r)
+	if err != nil {
+		return solution.Filter{}, err
+	}
+
+	return f, nil
+}
+
+// TaskListAPI answers a page of the tasks as JSON, filtered and sorted as
+// the admin task list is.
+func (h *TaskHandler) TaskListAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	f, err := taskFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p, err := page.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tasks, total, err := h.TaskService.GetTaskList(f, p)
+	if err == page.ErrBadPage {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get task list", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if tasks == nil {
+		tasks = []*task.Task{}
+	}
+
+	utils.WriteJSONHandler(w, page.NewList(tasks, total, p), http.StatusOK)
+}
+
+// SolutionListAPI answers a page of the solutions of the task as JSON,
+// those of the users the reviewer covers.
+func (h *TaskHandler) SolutionListAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	d, err := authz.FromContext(ctx)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get authorization from context", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	f, err := solutionFilter(r, d.Target.TaskID, d)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p, err := page.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	solutions, total, err := h.SolutionService.GetSolutions(f, p)
+	if err == page.ErrBadPage {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get solutions", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if solutions == nil {
+		solutions = []*solution.Solution{}
+	}
+
+	utils.WriteJSONHandler(w, page.NewList(solutions, total, p), http.StatusOK)
+}
@@ -3,18 +3,43 @@ package repo
 import (
 	"database/sql"
 	"github.com/lib/pq"
+	"grader/pkg/server/page"
 	"grader/pkg/server/task"
+	"strconv"
+	"time"
 )
 
 type Pgx struct {
 	DB *sql.DB
 }
 
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+	QueryRow(string, ...interface{}) *sql.Row
+}
+
 type TaskRepoInterface interface {
-	Add(*task.Task) error
-	Update(*task.Task) error
-	List(int, int) ([]*task.Task, error)
+	Add(*task.Task, *task.Revision) error
+	AddWithAssets(*task.Task, *task.Revision, []*task.Asset) error
+	Update(*task.Task, *task.Revision) error
+	UpdateWithAssets(*task.Task, *task.Revision, []*task.Asset) error
+	List(task.Filter, page.Page) ([]*task.Task, int, error)
+	ListForUser(string) ([]*task.Task, error)
 	Get(int) (*task.Task, error)
+	SetState(int, string, time.Time) error
+	Delete(int) error
+	Restore(int) error
+	ListRevisions(int) ([]*task.Revision, error)
+	GetRevision(int, int) (*task.Revision, error)
+	AddAsset(*task.Asset) error
+	RemoveAsset(int, string) error
+	ListAssets(int) ([]*task.Asset, error)
+	GetAsset(int, string) (*task.Asset, error)
+	SetAttachment(int, string, bool) error
+	AddStaff(*task.Staff) error
+	RemoveStaff(int, string) error
+	ListStaff(int) ([]*task.Staff, error)
 }
 
 func NewPgxRepo(db *sql.DB) *Pgx {
@@ -23,40 +48,360 @@ func NewPgxRepo(db *sql.DB) *Pgx {
 	}
 }
 
-func (repo *Pgx) Add(t *task.Task) error {
-	var taskID int
+// Add stores the task and its first revision in one transaction.
+func (repo *Pgx) Add(t *task.Task, rev *task.Revision) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	err := repo.DB.QueryRow(`
-		INSERT INTO tasks (name, description, admins, created_at)
-		VALUES ($1, $2, $3, NOW())
+	err = insertTask(tx, t, rev)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddWithAssets is Add also storing the assets of the task, their blobs
+// must be stored already.
+func (repo *Pgx) AddWithAssets(t *task.Task, rev *task.Revision, assets []*task.Asset) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertTask(tx, t, rev)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range assets {
+		a.TaskID = t.ID
+		err = addAsset(tx, a)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func insertTask(tx *sql.Tx, t *task.Task, rev *task.Revision) error {
+	err := tx.QueryRow(`
+		INSERT INTO tasks (name, description, language, image, network, large_memory, latest_only,
+		                   soft_deadline, hard_deadline, late_policy, penalty, course_id, state, publish_at,
+		                   time_limit, memory_limit, spec_version, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, 1, NOW())
 		RETURNING id;
-	`, t.Name, t.Description, pq.Array(t.Admins)).Scan(&taskID)
+	`, t.Name, t.Description,
+		t.Capabilities.Language, t.Capabilities.Image, t.Capabilities.Network, t.Capabilities.LargeMemory,
+		t.Policy.LatestOnly, nullTime(t.Policy.SoftDeadline), nullTime(t.Policy.HardDeadline), t.Policy.LatePolicy, t.Policy.Penalty,
+		nullID(t.CourseID), t.State, nullTime(t.PublishAt),
+		t.Capabilities.Limits.TimeLimit, t.Capabilities.Limits.MemoryLimit,
+	).Scan(&t.ID)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	rev.TaskID = t.ID
+
+	return addRevision(tx, rev)
 }
 
-func (repo *Pgx) Update(t *task.Task) error {
-	_, err := repo.DB.Exec(`
+// Update saves the task and, unless rev is nil, its new revision in one
+// transaction. The updated row stays locked until the commit, so
+// revision numbers of concurrent edits don't collide.
+func (repo *Pgx) Update(t *task.Task, rev *task.Revision) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateTask(tx, t, rev)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateWithAssets is Update also making the assets the ones of the task:
+// they are stored or replaced, the others removed. Their blobs must be
+// stored already.
+func (repo *Pgx) UpdateWithAssets(t *task.Task, rev *task.Revision, assets []*task.Asset) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateTask(tx, t, rev)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(assets))
+	for _, a := range assets {
+		names = append(names, a.Name)
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM task_assets
+		WHERE task_id = $1 AND NOT (name = ANY($2))
+	`, t.ID, pq.Array(names))
+	if err != nil {
+		return err
+	}
+
+	for _, a := range assets {
+		a.TaskID = t.ID
+		err = addAsset(tx, a)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func updateTask(tx *sql.Tx, t *task.Task, rev *task.Revision) error {
+	_, err := tx.Exec(`
 		UPDATE tasks 
-		SET name = $1, description = $2, admins = $3, created_at = $4
-		WHERE id = $5;
-	`, t.Name, t.Description, pq.Array(t.Admins), t.CreatedAt, t.ID)
+		SET name = $1, description = $2,
+		    language = $3, image = $4, network = $5, large_memory = $6, spec_version = $7, latest_only = $8,
+		    soft_deadline = $9, hard_deadline = $10, late_policy = $11, penalty = $12, course_id = $13,
+		    time_limit = $14, memory_limit = $15
+		WHERE id = $16;
+	`, t.Name, t.Description,
+		t.Capabilities.Language, t.Capabilities.Image, t.Capabilities.Network, t.Capabilities.LargeMemory,
+		t.SpecVersion, t.Policy.LatestOnly,
+		nullTime(t.Policy.SoftDeadline), nullTime(t.Policy.HardDeadline), t.Policy.LatePolicy, t.Policy.Penalty,
+		nullID(t.CourseID), t.Capabilities.Limits.TimeLimit, t.Capabilities.Limits.MemoryLimit, t.ID)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if rev == nil {
+		return nil
+	}
+
+	rev.TaskID = t.ID
+
+	return addRevision(tx, rev)
+}
+
+// addRevision numbers the revision next after the last one of its task.
+func addRevision(tx *sql.Tx, rev *task.Revision) error {
+	return tx.QueryRow(`
+		INSERT INTO task_revisions (task_id, number, name, description, language, image, network, large_memory,
+		                            latest_only, soft_deadline, hard_deadline, late_policy, penalty,
+		                            author_id, restored_from, time_limit, memory_limit, created_at)
+		SELECT $1, COALESCE(MAX(number), 0) + 1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, NOW()
+		FROM task_revisions
+		WHERE task_id = $1
+		RETURNING number, created_at
+	`, rev.TaskID, rev.Name, rev.Description,
+		rev.Capabilities.Language, rev.Capabilities.Image, rev.Capabilities.Network, rev.Capabilities.LargeMemory,
+		rev.Policy.LatestOnly, nullTime(rev.Policy.SoftDeadline), nullTime(rev.Policy.HardDeadline),
+		rev.Policy.LatePolicy, rev.Policy.Penalty,
+		sql.NullString{String: rev.AuthorID, Valid: rev.AuthorID != ""}, nullID(rev.RestoredFrom),
+		rev.Capabilities.Limits.TimeLimit, rev.Capabilities.Limits.MemoryLimit,
+	).Scan(&rev.Number, &rev.CreatedAt)
 }
 
-func (repo *Pgx) List(limit, offset int) ([]*task.Task, error) {
-	//TODO add limit offset
+// ListRevisions returns the revisions of the task, latest first.
+func (repo *Pgx) ListRevisions(taskID int) ([]*task.Revision, error) {
 	rows, err := repo.DB.Query(`
-		SELECT id, name, description, admins, created_at
+		SELECT `+revisionColumns+`
+		FROM task_revisions r
+		LEFT JOIN users u ON u.id = r.author_id
+		WHERE r.task_id = $1
+		ORDER BY r.number DESC
+	`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []*task.Revision
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, rev)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+func (repo *Pgx) GetRevision(taskID, number int) (*task.Revision, error) {
+	row := repo.DB.QueryRow(`
+		SELECT `+revisionColumns+`
+		FROM task_revisions r
+		LEFT JOIN users u ON u.id = r.author_id
+		WHERE r.task_id = $1 AND r.number = $2
+	`, taskID, number)
+
+	rev, err := scanRevision(row)
+	if err == sql.ErrNoRows {
+		return nil, task.ErrNoRevision
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return rev, nil
+}
+
+// taskSorts are the sort keys of task lists.
+var taskSorts = map[string]string{
+	"id":         "id",
+	"name":       "name",
+	"state":      "state",
+	"course":     "course_id",
+	"created_at": "created_at",
+	"deleted_at": "deleted_at",
+}
+
+// List returns the page of the tasks the filter lets through and how many
+// it lets through in all. Tasks go by id, the trash latest deleted first.
+func (repo *Pgx) List(f task.Filter, p page.Page) ([]*task.Task, int, error) {
+	where := &page.Where{}
+	if f.Deleted {
+		where.AddRaw("deleted_at IS NOT NULL")
+	} else {
+		where.AddRaw("deleted_at IS NULL")
+	}
+	if f.CourseID != 0 {
+		where.Add("course_id = ?", f.CourseID)
+	}
+	if f.NoCourse {
+		where.AddRaw("course_id IS NULL")
+	}
+	if f.State != "" {
+		where.Add("state = ?", f.State)
+	}
+	if f.Query != "" {
+		where.Add("name ILIKE '%' || ?::text || '%'", f.Query)
+	}
+	if !f.From.IsZero() {
+		where.Add("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		where.Add("created_at < ?", f.To)
+	}
+
+	def := "id"
+	if f.Deleted {
+		def = "-deleted_at"
+	}
+	order, err := p.SQL(taskSorts, def)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	err = repo.DB.QueryRow(`SELECT COUNT(*) FROM tasks `+where.String(), where.Args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	tasks, err := repo.listTasks(`
+		SELECT `+taskColumns+`
 		FROM tasks
-	`)
+		`+where.String()+`
+		`+order, where.Args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return tasks, total, nil
+}
+
+// ListForUser returns the tasks of the courses the user is enrolled in,
+// the tasks they are staff of and the tasks outside courses. Only staff
+// see tasks students don't see yet.
+func (repo *Pgx) ListForUser(userID string) ([]*task.Task, error) {
+	return repo.listTasks(`
+		SELECT `+taskColumns+`
+		FROM tasks
+		WHERE deleted_at IS NULL
+		  AND (course_id IS NULL
+		       OR course_id IN (SELECT course_id FROM enrollments WHERE user_id = $1)
+		       OR id IN (SELECT task_id FROM task_staff WHERE user_id = $1))
+		  AND (state IN ('published', 'archived')
+		       OR (state = 'scheduled' AND publish_at <= NOW())
+		       OR course_id IN (SELECT course_id FROM enrollments WHERE user_id = $1 AND role IN ('assistant', 'teacher'))
+		       OR id IN (SELECT task_id FROM task_staff WHERE user_id = $1))
+		ORDER BY id
+	`, userID)
+}
+
+// SetState moves a task that isn't deleted to the state, publishAt is
+// kept for scheduled tasks only.
+func (repo *Pgx) SetState(taskID int, state string, publishAt time.Time) error {
+	res, err := repo.DB.Exec(`
+		UPDATE tasks
+		SET state = $1, publish_at = $2
+		WHERE id = $3 AND deleted_at IS NULL
+	`, state, nullTime(publishAt), taskID)
+	if err != nil {
+		return err
+	}
+
+	return checkFound(res)
+}
+
+func (repo *Pgx) Delete(taskID int) error {
+	res, err := repo.DB.Exec(`
+		UPDATE tasks
+		SET deleted_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL
+	`, taskID)
+	if err != nil {
+		return err
+	}
+
+	return checkFound(res)
+}
+
+func (repo *Pgx) Restore(taskID int) error {
+	res, err := repo.DB.Exec(`
+		UPDATE tasks
+		SET deleted_at = NULL
+		WHERE id = $1 AND deleted_at IS NOT NULL
+	`, taskID)
+	if err != nil {
+		return err
+	}
+
+	return checkFound(res)
+}
+
+func checkFound(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return task.ErrNoTask
+	}
+
+	return nil
+}
+
+func (repo *Pgx) listTasks(query string, args ...interface{}) ([]*task.Task, error) {
+	rows, err := repo.DB.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -65,26 +410,12 @@ func (repo *Pgx) List(limit, offset int) ([]*task.Task, error) {
 	var tasks []*task.Task
 
 	for rows.Next() {
-		var t task.Task
-		var admins pq.Int64Array
-
-		err = rows.Scan(
-			&t.ID,
-			&t.Name,
-			&t.Description,
-			&admins,
-			&t.CreatedAt,
-		)
+		t, err := scanTask(rows)
 		if err != nil {
 			return nil, err
 		}
 
-		t.Admins = make([]int, len(admins))
-		for i, admin := range admins {
-			t.Admins[i] = int(admin)
-		}
-
-		tasks = append(tasks, &t)
+		tasks = append(tasks, t)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -95,34 +426,292 @@ func (repo *Pgx) List(limit, offset int) ([]*task.Task, error) {
 }
 
 func (repo *Pgx) Get(taskID int) (*task.Task, error) {
-	t := &task.Task{}
-
 	row := repo.DB.QueryRow(`
-		SELECT id, name, description, admins, created_at
+		SELECT `+taskColumns+`
 		FROM tasks
 		WHERE id = $1
 	`, taskID)
 
-	var admins pq.Int64Array
+	t, err := scanTask(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, task.ErrNoTask
+		}
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// AddAsset stores the asset or replaces the one of the task by that name.
+func (repo *Pgx) AddAsset(a *task.Asset) error {
+	return addAsset(repo.DB, a)
+}
+
+func addAsset(q queryer, a *task.Asset) error {
+	return q.QueryRow(`
+		INSERT INTO task_assets (task_id, name, content_type, size, hash, attachment, private, role, weight, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+		ON CONFLICT (task_id, name) DO UPDATE
+		SET content_type = EXCLUDED.content_type, size = EXCLUDED.size, hash = EXCLUDED.hash,
+		    attachment = EXCLUDED.attachment, private = EXCLUDED.private, role = EXCLUDED.role,
+		    weight = EXCLUDED.weight, created_at = EXCLUDED.created_at
+		RETURNING created_at
+	`, a.TaskID, a.Name, a.ContentType, a.Size, a.Hash, a.Attachment, a.Private, a.Role, a.Weight).Scan(&a.CreatedAt)
+}
+
+// RemoveAsset forgets the asset, its blob stays for other tasks sharing it.
+func (repo *Pgx) RemoveAsset(taskID int, name string) error {
+	res, err := repo.DB.Exec(`
+		DELETE FROM task_assets
+		WHERE task_id = $1 AND name = $2
+	`, taskID, name)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return task.ErrNoAsset
+	}
+
+	return nil
+}
+
+func (repo *Pgx) ListAssets(taskID int) ([]*task.Asset, error) {
+	rows, err := repo.DB.Query(`
+		SELECT task_id, name, content_type, size, hash, attachment, private, role, weight, created_at
+		FROM task_assets
+		WHERE task_id = $1
+		ORDER BY name
+	`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var assets []*task.Asset
+	for rows.Next() {
+		a := &task.Asset{}
+
+		err = rows.Scan(&a.TaskID, &a.Name, &a.ContentType, &a.Size, &a.Hash, &a.Attachment, &a.Private,
+			&a.Role, &a.Weight, &a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		assets = append(assets, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return assets, nil
+}
+
+func (repo *Pgx) GetAsset(taskID int, name string) (*task.Asset, error) {
+	a := &task.Asset{}
+
+	err := repo.DB.QueryRow(`
+		SELECT task_id, name, content_type, size, hash, attachment, private, role, weight, created_at
+		FROM task_assets
+		WHERE task_id = $1 AND name = $2
+	`, taskID, name).Scan(&a.TaskID, &a.Name, &a.ContentType, &a.Size, &a.Hash, &a.Attachment, &a.Private,
+		&a.Role, &a.Weight, &a.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, task.ErrNoAsset
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (repo *Pgx) SetAttachment(taskID int, name string, attachment bool) error {
+	res, err := repo.DB.Exec(`
+		UPDATE task_assets
+		SET attachment = $1
+		WHERE task_id = $2 AND name = $3 AND NOT private
+	`, attachment, taskID, name)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return task.ErrNoAsset
+	}
+
+	return nil
+}
+
+// AddStaff gives the user a role on the task or changes the one they have.
+func (repo *Pgx) AddStaff(st *task.Staff) error {
+	_, err := repo.DB.Exec(`
+		INSERT INTO task_staff (task_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (task_id, user_id) DO UPDATE
+		SET role = EXCLUDED.role
+	`, st.TaskID, st.UserID, st.Role)
+
+	return err
+}
+
+func (repo *Pgx) RemoveStaff(taskID int, userID string) error {
+	_, err := repo.DB.Exec(`
+		DELETE FROM task_staff
+		WHERE task_id = $1 AND user_id = $2
+	`, taskID, userID)
+
+	return err
+}
+
+func (repo *Pgx) ListStaff(taskID int) ([]*task.Staff, error) {
+	rows, err := repo.DB.Query(`
+		SELECT s.task_id, s.user_id, u.username, s.role
+		FROM task_staff s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.task_id = $1
+		ORDER BY u.username
+	`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var staff []*task.Staff
+	for rows.Next() {
+		st := &task.Staff{}
+		var userID int64
+
+		err = rows.Scan(&st.TaskID, &userID, &st.Username, &st.Role)
+		if err != nil {
+			return nil, err
+		}
+
+		st.UserID = strconv.FormatInt(userID, 10)
+		staff = append(staff, st)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return staff, nil
+}
+
+const taskColumns = `id, course_id, name, description, language, image, network, large_memory,
+		latest_only, soft_deadline, hard_deadline, late_policy, penalty, spec_version, state, publish_at,
+		deleted_at, created_at, time_limit, memory_limit`
+
+const revisionColumns = `r.task_id, r.number, r.name, r.description, r.language, r.image, r.network,
+		r.large_memory, r.latest_only, r.soft_deadline, r.hard_deadline, r.late_policy, r.penalty,
+		r.author_id, COALESCE(u.username, ''), r.restored_from, r.created_at, r.time_limit, r.memory_limit`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTask(row scanner) (*task.Task, error) {
+	t := &task.Task{}
+	var soft, hard, publishAt, deletedAt sql.NullTime
+	var courseID sql.NullInt64
 
 	err := row.Scan(
 		&t.ID,
+		&courseID,
 		&t.Name,
 		&t.Description,
-		&admins,
+		&t.Capabilities.Language,
+		&t.Capabilities.Image,
+		&t.Capabilities.Network,
+		&t.Capabilities.LargeMemory,
+		&t.Policy.LatestOnly,
+		&soft,
+		&hard,
+		&t.Policy.LatePolicy,
+		&t.Policy.Penalty,
+		&t.SpecVersion,
+		&t.State,
+		&publishAt,
+		&deletedAt,
 		&t.CreatedAt,
+		&t.Capabilities.Limits.TimeLimit,
+		&t.Capabilities.Limits.MemoryLimit,
 	)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, task.ErrNoTask
-		}
 		return nil, err
 	}
 
-	t.Admins = make([]int, len(admins))
-	for i, admin := range admins {
-		t.Admins[i] = int(admin)
-	}
+	t.CourseID = int(courseID.Int64)
+	t.Policy.SoftDeadline = soft.Time
+	t.Policy.HardDeadline = hard.Time
+	t.PublishAt = publishAt.Time
+	t.DeletedAt = deletedAt.Time
 
 	return t, nil
 }
+
+func scanRevision(row scanner) (*task.Revision, error) {
+	rev := &task.Revision{}
+	var soft, hard sql.NullTime
+	var authorID, restoredFrom sql.NullInt64
+
+	err := row.Scan(
+		&rev.TaskID,
+		&rev.Number,
+		&rev.Name,
+		&rev.Description,
+		&rev.Capabilities.Language,
+		&rev.Capabilities.Image,
+		&rev.Capabilities.Network,
+		&rev.Capabilities.LargeMemory,
+		&rev.Policy.LatestOnly,
+		&soft,
+		&hard,
+		&rev.Policy.LatePolicy,
+		&rev.Policy.Penalty,
+		&authorID,
+		&rev.Author,
+		&restoredFrom,
+		&rev.CreatedAt,
+		&rev.Capabilities.Limits.TimeLimit,
+		&rev.Capabilities.Limits.MemoryLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rev.Policy.SoftDeadline = soft.Time
+	rev.Policy.HardDeadline = hard.Time
+	if authorID.Valid {
+		rev.AuthorID = strconv.FormatInt(authorID.Int64, 10)
+	}
+	rev.RestoredFrom = int(restoredFrom.Int64)
+
+	return rev, nil
+}
+
+// nullTime stores the zero time, meaning no deadline, as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{
+		Time:  t,
+		Valid: !t.IsZero(),
+	}
+}
+
+// nullID stores the zero id, meaning none, as NULL.
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{
+		Int64: int64(id),
+		Valid: id != 0,
+	}
+}
@@ -0,0 +1,198 @@
+package task
+
+import (
+	"errors"
+	"grader/pkg/queue"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Revision is a saved version of the statement and the grading spec of a
+// task. Every change makes a new one, rollbacks too, so history is never
+// rewritten.
+type Revision struct {
+	TaskID       int
+	Number       int
+	Name         string
+	Description  string
+	Capabilities queue.Capabilities
+	Policy       Policy
+	AuthorID     string
+	Author       string
+	// RestoredFrom is the revision a rollback brought back, 0 for edits.
+	RestoredFrom int
+	CreatedAt    time.Time
+}
+
+var ErrNoRevision = errors.New("task revision not found")
+
+// Revision takes the statement and the spec of the task as they are now.
+func (t *Task) Revision() *Revision {
+	return &Revision{
+		TaskID:       t.ID,
+		Name:         t.Name,
+		Description:  t.Description,
+		Capabilities: t.Capabilities,
+		Policy:       t.Policy,
+	}
+}
+
+// Apply puts the revision back into the task, bumping the spec version
+// when the runtime changes.
+func (r *Revision) Apply(t *Task) {
+	t.Name = r.Name
+	t.Description = r.Description
+	t.Policy = r.Policy
+
+	if r.Capabilities != t.Capabilities {
+		t.Capabilities = r.Capabilities
+		t.SpecVersion++
+	}
+}
+
+// Same reports whether both revisions hold the same statement and spec.
+func (r *Revision) Same(o *Revision) bool {
+	return r.Description == o.Description && len(r.Changes(o)) == 0
+}
+
+// Change is a field that differs between two revisions.
+type Change struct {
+	Field string
+	From  string
+	To    string
+}
+
+// Changes lists the fields but the description that differ from prev.
+func (r *Revision) Changes(prev *Revision) []Change {
+	var changes []Change
+	add := func(field, from, to string) {
+		if from != to {
+			changes = append(changes, Change{Field: field, From: from, To: to})
+		}
+	}
+
+	add("Name", prev.Name, r.Name)
+	add("Language", prev.Capabilities.Language, r.Capabilities.Language)
+	add("Image", prev.Capabilities.Image, r.Capabilities.Image)
+	add("Network", strconv.FormatBool(prev.Capabilities.Network), strconv.FormatBool(r.Capabilities.Network))
+	add("Large memory", strconv.FormatBool(prev.Capabilities.LargeMemory), strconv.FormatBool(r.Capabilities.LargeMemory))
+	add("Time limit", formatLimit(prev.Capabilities.Limits.TimeLimit, "s"), formatLimit(r.Capabilities.Limits.TimeLimit, "s"))
+	add("Memory limit", formatLimit(prev.Capabilities.Limits.MemoryLimit, "MiB"), formatLimit(r.Capabilities.Limits.MemoryLimit, "MiB"))
+	add("Latest only", strconv.FormatBool(prev.Policy.LatestOnly), strconv.FormatBool(r.Policy.LatestOnly))
+	add("Soft deadline", formatTime(prev.Policy.SoftDeadline), formatTime(r.Policy.SoftDeadline))
+	add("Hard deadline", formatTime(prev.Policy.HardDeadline), formatTime(r.Policy.HardDeadline))
+	add("Late policy", prev.Policy.LatePolicy, r.Policy.LatePolicy)
+	add("Penalty", strconv.Itoa(prev.Policy.Penalty), strconv.Itoa(r.Policy.Penalty))
+
+	return changes
+}
+
+// formatLimit shows a limit in unit, zero is the grader default.
+func formatLimit(n int, unit string) string {
+	if n == 0 {
+		return "default"
+	}
+
+	return strconv.Itoa(n) + " " + unit
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "none"
+	}
+
+	return t.UTC().Format("2006-01-02 15:04 UTC")
+}
+
+// Line ops of a diff.
+const (
+	LineSame    = " "
+	LineAdded   = "+"
+	LineRemoved = "-"
+)
+
+type DiffLine struct {
+	Op   string
+	Text string
+}
+
+// Diff is what changed between two revisions.
+type Diff struct {
+	From    *Revision
+	To      *Revision
+	Changes []Change
+	Lines   []DiffLine
+}
+
+// Compare diffs to against from, a nil from being the empty task before
+// its first revision.
+func Compare(from, to *Revision) *Diff {
+	prev := from
+	if prev == nil {
+		prev = &Revision{}
+	}
+
+	return &Diff{
+		From:    from,
+		To:      to,
+		Changes: to.Changes(prev),
+		Lines:   DiffLines(prev.Description, to.Description),
+	}
+}
+
+// DiffLines is a line diff of two texts by their longest common
+// subsequence. Statements are short, the quadratic table is fine.
+func DiffLines(a, b string) []DiffLine {
+	x := splitLines(a)
+	y := splitLines(b)
+
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []DiffLine
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			lines = append(lines, DiffLine{Op: LineSame, Text: x[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, DiffLine{Op: LineRemoved, Text: x[i]})
+			i++
+		default:
+			lines = append(lines, DiffLine{Op: LineAdded, Text: y[j]})
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		lines = append(lines, DiffLine{Op: LineRemoved, Text: x[i]})
+	}
+	for ; j < len(y); j++ {
+		lines = append(lines, DiffLine{Op: LineAdded, Text: y[j]})
+	}
+
+	return lines
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+}
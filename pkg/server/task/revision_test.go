@@ -0,0 +1,144 @@
+package task
+
+import (
+	"grader/pkg/queue"
+	"reflect"
+	"testing"
+)
+
+func TestRevisionApply(t *testing.T) {
+	caps := queue.Capabilities{Language: "go", Image: "golangcourse_final"}
+
+	tests := []struct {
+		name        string
+		caps        queue.Capabilities
+		wantVersion int
+	}{
+		{name: "statement only", caps: caps, wantVersion: 1},
+		{name: "runtime", caps: queue.Capabilities{Language: "go", Image: "golangcourse_final", Network: true}, wantVersion: 2},
+	}
+
+	for _, tt := range tests {
+		task := &Task{Name: "old", Description: "old", Capabilities: caps, SpecVersion: 1}
+
+		rev := &Revision{Name: "new", Description: "new", Capabilities: tt.caps, Policy: Policy{LatePolicy: LateFlag}}
+		rev.Apply(task)
+
+		if task.Name != "new" || task.Description != "new" || task.Policy.LatePolicy != LateFlag {
+			t.Errorf("%s: Apply() left %+v", tt.name, task)
+		}
+		if task.Capabilities != tt.caps {
+			t.Errorf("%s: Capabilities = %+v, want %+v", tt.name, task.Capabilities, tt.caps)
+		}
+		if task.SpecVersion != tt.wantVersion {
+			t.Errorf("%s: SpecVersion = %d, want %d", tt.name, task.SpecVersion, tt.wantVersion)
+		}
+	}
+}
+
+func TestRevisionChanges(t *testing.T) {
+	prev := &Revision{
+		Name:         "Hello",
+		Description:  "Say hello.",
+		Capabilities: queue.Capabilities{Language: "go", Image: "golangcourse_final"},
+		Policy:       Policy{LatePolicy: LateFlag},
+	}
+
+	tests := []struct {
+		name string
+		edit func(*Revision)
+		want []Change
+	}{
+		{
+			name: "same",
+			edit: func(*Revision) {},
+		},
+		{
+			name: "description is not a change",
+			edit: func(r *Revision) { r.Description = "Say hello twice." },
+		},
+		{
+			name: "name and runtime",
+			edit: func(r *Revision) {
+				r.Name = "Hello, World"
+				r.Capabilities.LargeMemory = true
+			},
+			want: []Change{
+				{Field: "Name", From: "Hello", To: "Hello, World"},
+				{Field: "Large memory", From: "false", To: "true"},
+			},
+		},
+		{
+			name: "deadline and penalty",
+			edit: func(r *Revision) {
+				r.Policy.SoftDeadline = soft
+				r.Policy.LatePolicy = LateStep
+				r.Policy.Penalty = 20
+			},
+			want: []Change{
+				{Field: "Soft deadline", From: "none", To: "2024-03-01 23:59 UTC"},
+				{Field: "Late policy", From: LateFlag, To: LateStep},
+				{Field: "Penalty", From: "0", To: "20"},
+			},
+		},
+		{
+			name: "limits",
+			edit: func(r *Revision) {
+				r.Capabilities.Limits = queue.Limits{TimeLimit: 30}
+			},
+			want: []Change{
+				{Field: "Time limit", From: "default", To: "30 s"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		r := *prev
+		tt.edit(&r)
+
+		got := r.Changes(prev)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Changes() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []DiffLine
+	}{
+		{name: "both empty"},
+		{
+			name: "added to empty",
+			b:    "one\ntwo",
+			want: []DiffLine{{LineAdded, "one"}, {LineAdded, "two"}},
+		},
+		{
+			name: "line changed",
+			a:    "one\ntwo\nthree",
+			b:    "one\n2\nthree",
+			want: []DiffLine{{LineSame, "one"}, {LineRemoved, "two"}, {LineAdded, "2"}, {LineSame, "three"}},
+		},
+		{
+			name: "line removed at the end",
+			a:    "one\ntwo",
+			b:    "one",
+			want: []DiffLine{{LineSame, "one"}, {LineRemoved, "two"}},
+		},
+		{
+			name: "windows line ends",
+			a:    "one\r\ntwo",
+			b:    "one\ntwo",
+			want: []DiffLine{{LineSame, "one"}, {LineSame, "two"}},
+		},
+	}
+
+	for _, tt := range tests {
+		got := DiffLines(tt.a, tt.b)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: DiffLines() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
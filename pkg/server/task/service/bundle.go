@@ -0,0 +1,216 @@
+package service
+
+import (
+	"grader/pkg/bundle"
+	"grader/pkg/server/page"
+	"grader/pkg/server/task"
+	"strconv"
+	"time"
+)
+
+// ExportTask packs the statement, the spec and the files of the task.
+func (h *TaskService) ExportTask(taskID int) (*bundle.Bundle, error) {
+	t, err := h.TaskRepoPQ.Get(taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &bundle.Bundle{
+		Manifest: bundle.Manifest{
+			Format:  bundle.Format,
+			Name:    t.Name,
+			Grading: t.Capabilities,
+			Policy: bundle.Policy{
+				LatestOnly:   t.Policy.LatestOnly,
+				SoftDeadline: timePtr(t.Policy.SoftDeadline),
+				HardDeadline: timePtr(t.Policy.HardDeadline),
+				LatePolicy:   t.Policy.LatePolicy,
+				Penalty:      t.Policy.Penalty,
+			},
+		},
+		Statement: t.Description,
+		Files:     map[string][]byte{},
+	}
+
+	assets, err := h.TaskRepoPQ.ListAssets(t.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range assets {
+		b.Files[a.Name], err = h.BlobRepo.Get(a.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		b.Manifest.Files = append(b.Manifest.Files, bundle.File{
+			Name:       a.Name,
+			Attachment: a.Attachment,
+			Private:    a.Private,
+			Role:       a.Role,
+			Weight:     a.Weight,
+		})
+	}
+
+	return b, nil
+}
+
+// ExportCourse bundles the tasks of the course, 0 for the tasks outside
+// courses, by the directory each goes to.
+func (h *TaskService) ExportCourse(courseID int) (map[string]*bundle.Bundle, error) {
+	tasks, _, err := h.TaskRepoPQ.List(task.Filter{
+		CourseID: courseID,
+		NoCourse: courseID == 0,
+	}, page.Page{})
+	if err != nil {
+		return nil, err
+	}
+
+	bundles := map[string]*bundle.Bundle{}
+	taken := map[string]bool{}
+	for _, t := range tasks {
+		b, err := h.ExportTask(t.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		bundles[bundle.Dir(t.Name, taken)] = b
+	}
+
+	return bundles, nil
+}
+
+// ImportTask creates a draft task in the course from the bundle and
+// returns its id.
+func (h *TaskService) ImportTask(b *bundle.Bundle, courseID int, authorID string) (int, error) {
+	policy, err := bundlePolicy(b)
+	if err != nil {
+		return 0, err
+	}
+
+	t := &task.Task{
+		CourseID:     courseID,
+		Name:         b.Manifest.Name,
+		Description:  b.Statement,
+		Capabilities: b.Manifest.Grading.WithDefaults(),
+		Policy:       policy,
+		State:        task.StateDraft,
+	}
+
+	rev := t.Revision()
+	rev.AuthorID = authorID
+
+	assets, err := h.bundleAssets(b)
+	if err != nil {
+		return 0, err
+	}
+
+	err = h.TaskRepoPQ.AddWithAssets(t, rev, assets)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.ID, nil
+}
+
+// SyncTask makes the task what the bundle says: the statement and the
+// spec change as an edit by the author would, the files are replaced and
+// the ones the bundle lacks removed. The course and the state stay.
+func (h *TaskService) SyncTask(taskID int, b *bundle.Bundle, authorID string) error {
+	policy, err := bundlePolicy(b)
+	if err != nil {
+		return err
+	}
+
+	t, err := h.TaskRepoPQ.Get(taskID)
+	if err != nil {
+		return err
+	}
+
+	t, rev, err := h.edit(b.Manifest.Name, b.Statement, strconv.Itoa(t.ID), t.CourseID,
+		b.Manifest.Grading, policy, authorID)
+	if err != nil {
+		return err
+	}
+
+	assets, err := h.bundleAssets(b)
+	if err != nil {
+		return err
+	}
+
+	return h.TaskRepoPQ.UpdateWithAssets(t, rev, assets)
+}
+
+// bundleAssets stores the blobs of the bundle files and returns their
+// assets, for the task and its assets to be stored in one go.
+func (h *TaskService) bundleAssets(b *bundle.Bundle) ([]*task.Asset, error) {
+	var assets []*task.Asset
+
+	for _, f := range b.Manifest.Files {
+		a := &task.Asset{
+			Name:       f.Name,
+			Attachment: f.Attachment,
+			Private:    f.Private,
+			Role:       f.Role,
+			Weight:     f.Weight,
+		}
+
+		err := h.putAsset(a, b.Files[f.Name])
+		if err != nil {
+			return nil, err
+		}
+
+		assets = append(assets, a)
+	}
+
+	return assets, nil
+}
+
+// bundlePolicy checks the bundle before its blobs are stored. The task and
+// its assets then go in one transaction, so a bad bundle or a failed
+// store leaves no half imported task.
+func bundlePolicy(b *bundle.Bundle) (task.Policy, error) {
+	err := b.Validate()
+	if err != nil {
+		return task.Policy{}, err
+	}
+	err = b.Manifest.Grading.Validate()
+	if err != nil {
+		return task.Policy{}, err
+	}
+
+	for _, f := range b.Manifest.Files {
+		if !task.ValidAssetName(f.Name) || len(b.Files[f.Name]) > task.MaxAssetSize {
+			return task.Policy{}, task.ErrBadAsset
+		}
+	}
+
+	p := b.Manifest.Policy
+	policy := task.Policy{
+		LatestOnly: p.LatestOnly,
+		LatePolicy: p.LatePolicy,
+		Penalty:    p.Penalty,
+	}
+	if p.SoftDeadline != nil {
+		policy.SoftDeadline = *p.SoftDeadline
+	}
+	if p.HardDeadline != nil {
+		policy.HardDeadline = *p.HardDeadline
+	}
+
+	policy = policy.WithDefaults()
+	err = policy.Validate()
+	if err != nil {
+		return task.Policy{}, err
+	}
+
+	return policy, nil
+}
+
+// timePtr leaves the zero time, no deadline, out of the manifest.
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
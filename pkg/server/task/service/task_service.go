@@ -1,68 +1,164 @@
 package service
 
 import (
-	"fmt"
-	"grader/pkg/server/solution"
+	"archive/zip"
+	"grader/pkg/bundle"
+	"grader/pkg/queue"
+	"grader/pkg/server/blob"
+	blobRepo "grader/pkg/server/blob/repo"
+	"grader/pkg/server/page"
 	"grader/pkg/server/task"
 	"grader/pkg/server/task/repo"
+	"io"
+	"mime"
+	"net/http"
+	"path"
 	"strconv"
+	"time"
 )
 
 type TaskServiceInterface interface {
-	GetTaskList() ([]*task.Task, error)
+	GetTaskList(task.Filter, page.Page) ([]*task.Task, int, error)
 	GetTaskByID(string) (*task.Task, error)
-	CreateTask(string, string) error
-	UpdateTask(string, string, string) error
-	GetTasksByUserSolutions([]*solution.Solution) ([]*task.Task, error)
+	GetTasksForUser(string) ([]*task.Task, error)
+	CreateTask(string, string, int, queue.Capabilities, task.Policy, string) error
+	UpdateTask(string, string, string, int, queue.Capabilities, task.Policy, string) error
+	GetRevisions(string) ([]*task.Revision, error)
+	RollbackTask(string, int, string) error
+	SetTaskState(string, string, time.Time) error
+	DeleteTask(string) error
+	RestoreTask(string) error
+	AddAsset(*task.Asset, []byte) error
+	RemoveAsset(int, string) error
+	GetAssets(int) ([]*task.Asset, error)
+	GetAsset(int, string) (*task.Asset, []byte, error)
+	SetAttachment(int, string, bool) error
+	GetAttachments(int) ([]*task.Asset, error)
+	WriteAttachments(int, io.Writer) error
+	ExportTask(int) (*bundle.Bundle, error)
+	ExportCourse(int) (map[string]*bundle.Bundle, error)
+	ImportTask(*bundle.Bundle, int, string) (int, error)
+	SyncTask(int, *bundle.Bundle, string) error
+	AddStaff(int, string, string) error
+	RemoveStaff(int, string) error
+	GetStaff(int) ([]*task.Staff, error)
 }
 
 type TaskService struct {
 	TaskRepoPQ repo.TaskRepoInterface
+	BlobRepo   blobRepo.BlobRepoInterface
 }
 
-func NewTaskService(pgx repo.TaskRepoInterface) *TaskService {
+func NewTaskService(pgx repo.TaskRepoInterface, blobs blobRepo.BlobRepoInterface) *TaskService {
 	return &TaskService{
 		TaskRepoPQ: pgx,
+		BlobRepo:   blobs,
 	}
 }
 
-func (h *TaskService) GetTasksByUserSolutions(solutions []*solution.Solution) ([]*task.Task, error) {
-	var tasks []*task.Task
+// GetTasksForUser returns the tasks the user sees by their enrollments.
+func (h *TaskService) GetTasksForUser(userID string) ([]*task.Task, error) {
+	return h.TaskRepoPQ.ListForUser(userID)
+}
 
-	taskIDs := make(map[int]bool)
-	var filteredTaskIDs []string
+// UpdateTask saves the task, recording a revision by the author when the
+// statement or the spec change.
+func (h *TaskService) UpdateTask(name, description, taskID string, courseID int, caps queue.Capabilities, policy task.Policy, authorID string) error {
+	t, rev, err := h.edit(name, description, taskID, courseID, caps, policy, authorID)
+	if err != nil {
+		return err
+	}
 
-	for _, s := range solutions {
-		if _, ok := taskIDs[s.TaskID]; !ok {
-			taskIDs[s.TaskID] = true
-			tID := fmt.Sprintf("%d", s.TaskID)
+	return h.TaskRepoPQ.Update(t, rev)
+}
 
-			filteredTaskIDs = append(filteredTaskIDs, tID)
-		}
+// edit returns the task as UpdateTask saves it and its new revision, nil
+// when the statement and the spec stay.
+func (h *TaskService) edit(name, description, taskID string, courseID int, caps queue.Capabilities, policy task.Policy, authorID string) (*task.Task, *task.Revision, error) {
+	policy = policy.WithDefaults()
+	err := policy.Validate()
+	if err != nil {
+		return nil, nil, err
+	}
+	err = caps.Validate()
+	if err != nil {
+		return nil, nil, err
 	}
 
-	for _, i := range filteredTaskIDs {
-		t, err := h.GetTaskByID(i)
-		if err != nil {
-			return nil, err
-		}
+	t, err := h.GetTaskByID(taskID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t.Deleted() {
+		return nil, nil, task.ErrNoTask
+	}
+	if t.Archived() {
+		return nil, nil, task.ErrArchived
+	}
+
+	prev := t.Revision()
+
+	rev := &task.Revision{
+		Name:         name,
+		Description:  description,
+		Capabilities: caps.WithDefaults(),
+		Policy:       policy,
+		AuthorID:     authorID,
+	}
+	rev.Apply(t)
+	t.CourseID = courseID
 
-		tasks = append(tasks, t)
+	if rev.Same(prev) {
+		rev = nil
 	}
 
-	return tasks, nil
+	return t, rev, nil
 }
 
-func (h *TaskService) UpdateTask(name, description, taskID string) error {
-	t, err := h.GetTaskByID(taskID)
+// GetTaskList returns the page of the tasks the filter lets through and
+// how many it lets through in all.
+func (h *TaskService) GetTaskList(f task.Filter, p page.Page) ([]*task.Task, int, error) {
+	return h.TaskRepoPQ.List(f, p)
+}
+
+func (h *TaskService) GetTaskByID(taskID string) (*task.Task, error) {
+	id, err := strconv.Atoi(taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := h.TaskRepoPQ.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (h *TaskService) CreateTask(name, description string, courseID int, caps queue.Capabilities, policy task.Policy, authorID string) error {
+	policy = policy.WithDefaults()
+	err := policy.Validate()
+	if err != nil {
+		return err
+	}
+	err = caps.Validate()
 	if err != nil {
 		return err
 	}
 
-	t.Name = name
-	t.Description = description
+	t := &task.Task{
+		CourseID:     courseID,
+		Name:         name,
+		Description:  description,
+		Capabilities: caps.WithDefaults(),
+		Policy:       policy,
+		State:        task.StateDraft,
+	}
 
-	err = h.TaskRepoPQ.Update(t)
+	rev := t.Revision()
+	rev.AuthorID = authorID
+
+	err = h.TaskRepoPQ.Add(t, rev)
 	if err != nil {
 		return err
 	}
@@ -70,40 +166,231 @@ func (h *TaskService) UpdateTask(name, description, taskID string) error {
 	return nil
 }
 
-func (h *TaskService) GetTaskList() ([]*task.Task, error) {
-	//TODO added with query params limit offset
-	t, err := h.TaskRepoPQ.List(0, 15)
+func (h *TaskService) GetRevisions(taskID string) ([]*task.Revision, error) {
+	id, err := strconv.Atoi(taskID)
 	if err != nil {
 		return nil, err
 	}
 
-	return t, err
+	return h.TaskRepoPQ.ListRevisions(id)
 }
 
-func (h *TaskService) GetTaskByID(taskID string) (*task.Task, error) {
+// RollbackTask brings back the statement and the spec of an earlier
+// revision as a new one, the course and the state stay.
+func (h *TaskService) RollbackTask(taskID string, number int, authorID string) error {
+	t, err := h.GetTaskByID(taskID)
+	if err != nil {
+		return err
+	}
+	if t.Deleted() {
+		return task.ErrNoTask
+	}
+	if t.Archived() {
+		return task.ErrArchived
+	}
+
+	rev, err := h.TaskRepoPQ.GetRevision(t.ID, number)
+	if err != nil {
+		return err
+	}
+
+	prev := t.Revision()
+	rev.Apply(t)
+	if rev.Same(prev) {
+		return nil
+	}
+
+	rev.AuthorID = authorID
+	rev.RestoredFrom = number
+
+	return h.TaskRepoPQ.Update(t, rev)
+}
+
+// SetTaskState moves the task through its lifecycle, scheduled tasks need
+// the time they go live.
+func (h *TaskService) SetTaskState(taskID, state string, publishAt time.Time) error {
 	id, err := strconv.Atoi(taskID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	t, err := h.TaskRepoPQ.Get(id)
+	if !task.ValidState(state) {
+		return task.ErrBadState
+	}
+	if state != task.StateScheduled {
+		publishAt = time.Time{}
+	} else if publishAt.IsZero() {
+		return task.ErrBadState
+	}
+
+	return h.TaskRepoPQ.SetState(id, state, publishAt)
+}
+
+// DeleteTask moves the task to the trash, its solutions stay.
+func (h *TaskService) DeleteTask(taskID string) error {
+	id, err := strconv.Atoi(taskID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return t, nil
+	return h.TaskRepoPQ.Delete(id)
 }
 
-func (h *TaskService) CreateTask(name, description string) error {
-	t := &task.Task{
-		Name:        name,
-		Description: description,
+func (h *TaskService) RestoreTask(taskID string) error {
+	id, err := strconv.Atoi(taskID)
+	if err != nil {
+		return err
+	}
+
+	return h.TaskRepoPQ.Restore(id)
+}
+
+// AddAsset stores the file as the asset, replacing an asset of the task
+// by that name. A task has one reference solution at most.
+func (h *TaskService) AddAsset(a *task.Asset, data []byte) error {
+	if a.Role == task.AssetReference {
+		assets, err := h.TaskRepoPQ.ListAssets(a.TaskID)
+		if err != nil {
+			return err
+		}
+		for _, other := range assets {
+			if other.Role == task.AssetReference && other.Name != a.Name {
+				return task.ErrTwoReferences
+			}
+		}
 	}
 
-	err := h.TaskRepoPQ.Add(t)
+	err := h.putAsset(a, data)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return h.TaskRepoPQ.AddAsset(a)
+}
+
+// putAsset checks the file and stores its bytes in the blob store, the
+// asset row is up to the caller. The content type comes from the
+// extension, the bytes when it is unknown.
+func (h *TaskService) putAsset(a *task.Asset, data []byte) error {
+	if !task.ValidAssetName(a.Name) || len(data) > task.MaxAssetSize {
+		return task.ErrBadAsset
+	}
+	err := a.ValidateRole()
+	if err != nil {
+		return err
+	}
+
+	a.ContentType = mime.TypeByExtension(path.Ext(a.Name))
+	if a.ContentType == "" {
+		a.ContentType = http.DetectContentType(data)
+	}
+	a.Size = int64(len(data))
+	if a.Private {
+		a.Attachment = false
+	}
+
+	a.Hash, err = h.BlobRepo.Put(data)
+
+	return err
+}
+
+func (h *TaskService) RemoveAsset(taskID int, name string) error {
+	return h.TaskRepoPQ.RemoveAsset(taskID, name)
+}
+
+func (h *TaskService) GetAssets(taskID int) ([]*task.Asset, error) {
+	return h.TaskRepoPQ.ListAssets(taskID)
+}
+
+func (h *TaskService) GetAsset(taskID int, name string) (*task.Asset, []byte, error) {
+	a, err := h.TaskRepoPQ.GetAsset(taskID, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := h.BlobRepo.Get(a.Hash)
+	if err == blob.ErrNoBlob {
+		return nil, nil, task.ErrNoAsset
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return a, data, nil
+}
+
+func (h *TaskService) SetAttachment(taskID int, name string, attachment bool) error {
+	return h.TaskRepoPQ.SetAttachment(taskID, name, attachment)
+}
+
+func (h *TaskService) GetAttachments(taskID int) ([]*task.Asset, error) {
+	assets, err := h.TaskRepoPQ.ListAssets(taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	var attachments []*task.Asset
+	for _, a := range assets {
+		if a.Attachment {
+			attachments = append(attachments, a)
+		}
+	}
+
+	return attachments, nil
+}
+
+// WriteAttachments writes the attachments of the task as a zip archive,
+// task.ErrNoAsset when there are none.
+func (h *TaskService) WriteAttachments(taskID int, w io.Writer) error {
+	attachments, err := h.GetAttachments(taskID)
+	if err != nil {
+		return err
+	}
+	if len(attachments) == 0 {
+		return task.ErrNoAsset
+	}
+
+	zw := zip.NewWriter(w)
+	for _, a := range attachments {
+		data, err := h.BlobRepo.Get(a.Hash)
+		if err != nil {
+			return err
+		}
+
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     a.Name,
+			Method:   zip.Deflate,
+			Modified: a.CreatedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = f.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func (h *TaskService) AddStaff(taskID int, userID, role string) error {
+	if role != task.StaffAssistant && role != task.StaffTeacher {
+		return task.ErrBadStaff
+	}
+
+	return h.TaskRepoPQ.AddStaff(&task.Staff{
+		TaskID: taskID,
+		UserID: userID,
+		Role:   role,
+	})
+}
+
+func (h *TaskService) RemoveStaff(taskID int, userID string) error {
+	return h.TaskRepoPQ.RemoveStaff(taskID, userID)
+}
+
+func (h *TaskService) GetStaff(taskID int) ([]*task.Staff, error) {
+	return h.TaskRepoPQ.ListStaff(taskID)
 }
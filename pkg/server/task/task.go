@@ -2,15 +2,256 @@ package task
 
 import (
 	"errors"
+	"grader/pkg/queue"
 	"time"
 )
 
 type Task struct {
-	ID          int
-	Name        string
-	Description string
-	Admins      []int
-	CreatedAt   time.Time
+	ID int
+	// CourseID is the course the task belongs to, 0 for tasks every user
+	// sees.
+	CourseID     int
+	Name         string
+	Description  string
+	Capabilities queue.Capabilities
+	Policy       Policy
+	// SpecVersion is bumped whenever grading settings change.
+	SpecVersion int
+	State       string
+	// PublishAt is when a scheduled task goes live.
+	PublishAt time.Time
+	// DeletedAt is set for tasks in the trash, they can be restored.
+	DeletedAt time.Time
+	CreatedAt time.Time
 }
 
-var ErrNoTask = errors.New("task not found")
+// States of a task. Students see published and archived tasks, and
+// scheduled ones once PublishAt has come.
+const (
+	StateDraft     = "draft"
+	StateScheduled = "scheduled"
+	StatePublished = "published"
+	// StateArchived tasks are read-only: no uploads, edits or regrades,
+	// their solutions stay.
+	StateArchived = "archived"
+)
+
+func ValidState(state string) bool {
+	return state == StateDraft || state == StateScheduled || state == StatePublished || state == StateArchived
+}
+
+func (t *Task) Deleted() bool {
+	return !t.DeletedAt.IsZero()
+}
+
+// Visible reports whether students see the task at now.
+func (t *Task) Visible(now time.Time) bool {
+	if t.Deleted() {
+		return false
+	}
+
+	switch t.State {
+	case StatePublished, StateArchived:
+		return true
+	case StateScheduled:
+		return !now.Before(t.PublishAt)
+	default:
+		return false
+	}
+}
+
+// Live reports whether students see the task now.
+func (t *Task) Live() bool {
+	return t.Visible(time.Now())
+}
+
+func (t *Task) Archived() bool {
+	return t.State == StateArchived
+}
+
+// Filter narrows a task list, zero fields don't.
+type Filter struct {
+	// CourseID lists the tasks of the course, NoCourse those outside
+	// courses.
+	CourseID int
+	NoCourse bool
+	State    string
+	// Query is part of the name, in any case.
+	Query string
+	// From and To bound the creation time, To excluded.
+	From time.Time
+	To   time.Time
+	// Deleted lists the tasks in the trash instead of the others.
+	Deleted bool
+}
+
+// Accepts checks an upload at now. Staff may upload to tasks students
+// don't see yet to try them.
+func (t *Task) Accepts(now time.Time, staff bool) error {
+	switch {
+	case t.Deleted():
+		return ErrNoTask
+	case t.Archived():
+		return ErrArchived
+	case !staff && !t.Visible(now):
+		return ErrNotPublished
+	}
+
+	return nil
+}
+
+// Late policies say what happens to uploads after the soft deadline.
+const (
+	LateReject = "reject"
+	// LateLinear takes Penalty percent off the score per started day late.
+	LateLinear = "linear"
+	// LateStep takes Penalty percent off the score once.
+	LateStep = "step"
+	// LateFlag accepts late uploads in full, they are only marked late.
+	LateFlag = "flag"
+)
+
+// DeadlineLayout is what datetime-local inputs send.
+const DeadlineLayout = "2006-01-02T15:04"
+
+// ParseDeadline reads a deadline form value in server local time, empty
+// means no deadline.
+func ParseDeadline(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	return time.ParseInLocation(DeadlineLayout, v, time.Local)
+}
+
+// Policy holds the submission rules of a task.
+type Policy struct {
+	// LatestOnly cancels the older solutions of a user still queued when
+	// they upload a new one, ones already with a grader finish.
+	LatestOnly bool
+	// SoftDeadline is when uploads start to be late, zero means never.
+	SoftDeadline time.Time
+	// HardDeadline is when uploads stop being accepted, zero means never.
+	HardDeadline time.Time
+	LatePolicy   string
+	// Penalty is in percent of the score, see the late policies.
+	Penalty int
+}
+
+// Lateness is how long after the soft deadline an upload at t is.
+func (p Policy) Lateness(t time.Time) time.Duration {
+	if p.SoftDeadline.IsZero() || !t.After(p.SoftDeadline) {
+		return 0
+	}
+
+	return t.Sub(p.SoftDeadline)
+}
+
+// Closed reports whether uploads at t are past the hard deadline.
+func (p Policy) Closed(t time.Time) bool {
+	return !p.HardDeadline.IsZero() && t.After(p.HardDeadline)
+}
+
+// NextDeadline is the first deadline after t, zero when none is left.
+func (p Policy) NextDeadline(t time.Time) time.Time {
+	if !p.SoftDeadline.IsZero() && t.Before(p.SoftDeadline) {
+		return p.SoftDeadline
+	}
+	if !p.HardDeadline.IsZero() && t.Before(p.HardDeadline) {
+		return p.HardDeadline
+	}
+
+	return time.Time{}
+}
+
+// DaysLate counts every started day of late as a whole one.
+func DaysLate(late time.Duration) int {
+	return int((late + 24*time.Hour - 1) / (24 * time.Hour))
+}
+
+// Admit checks an upload at t against the deadlines and returns how late it
+// is and the penalty in percent it gets.
+func (p Policy) Admit(t time.Time) (time.Duration, int, error) {
+	if p.Closed(t) {
+		return 0, 0, ErrPastDeadline
+	}
+
+	late := p.Lateness(t)
+	if late == 0 {
+		return 0, 0, nil
+	}
+
+	switch p.LatePolicy {
+	case LateReject:
+		return 0, 0, ErrPastDeadline
+	case LateLinear:
+		return late, clampPenalty(DaysLate(late) * p.Penalty), nil
+	case LateStep:
+		return late, clampPenalty(p.Penalty), nil
+	default:
+		return late, 0, nil
+	}
+}
+
+func clampPenalty(penalty int) int {
+	if penalty < 0 {
+		return 0
+	}
+	if penalty > 100 {
+		return 100
+	}
+
+	return penalty
+}
+
+// WithDefaults fills the late policy left empty, late uploads are flagged.
+func (p Policy) WithDefaults() Policy {
+	if p.LatePolicy == "" {
+		p.LatePolicy = LateFlag
+	}
+
+	return p
+}
+
+// Validate checks the policy settings make sense together.
+func (p Policy) Validate() error {
+	switch p.LatePolicy {
+	case LateReject, LateLinear, LateStep, LateFlag:
+	default:
+		return ErrBadPolicy
+	}
+
+	if p.Penalty < 0 || p.Penalty > 100 {
+		return ErrBadPolicy
+	}
+	if !p.SoftDeadline.IsZero() && !p.HardDeadline.IsZero() && p.HardDeadline.Before(p.SoftDeadline) {
+		return ErrBadPolicy
+	}
+
+	return nil
+}
+
+// Staff is a role a user has on one task only, on top of the roles of
+// the course. Task staff see every student of the task.
+type Staff struct {
+	TaskID   int
+	UserID   string
+	Username string
+	Role     string
+}
+
+// Roles task staff may have, they are named as in courses.
+const (
+	StaffAssistant = "assistant"
+	StaffTeacher   = "teacher"
+)
+
+var (
+	ErrNoTask       = errors.New("task not found")
+	ErrPastDeadline = errors.New("the task deadline has passed, uploads are closed")
+	ErrBadStaff     = errors.New("bad task staff: role must be assistant or teacher")
+	ErrBadState     = errors.New("bad task state: draft, scheduled with a publish time, published or archived")
+	ErrArchived     = errors.New("the task is archived, it is read-only")
+	ErrNotPublished = errors.New("the task is not published yet")
+	ErrBadPolicy    = errors.New("bad task policy: late policy must be reject, linear, step or flag, penalty 0-100 and the hard deadline not before the soft one")
+)
@@ -0,0 +1,220 @@
+package task
+
+import (
+	"testing"
+	"time"
+)
+
+var (
+	soft = time.Date(2024, 3, 1, 23, 59, 0, 0, time.UTC)
+	hard = soft.Add(7 * 24 * time.Hour)
+)
+
+func TestDaysLate(t *testing.T) {
+	tests := []struct {
+		late time.Duration
+		want int
+	}{
+		{0, 0},
+		{time.Second, 1},
+		{24 * time.Hour, 1},
+		{24*time.Hour + time.Second, 2},
+		{72 * time.Hour, 3},
+	}
+
+	for _, tt := range tests {
+		got := DaysLate(tt.late)
+		if got != tt.want {
+			t.Errorf("DaysLate(%v) = %d, want %d", tt.late, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyAdmit(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      Policy
+		at          time.Time
+		wantLate    time.Duration
+		wantPenalty int
+		wantErr     error
+	}{
+		{
+			name:   "no deadlines",
+			policy: Policy{LatePolicy: LateReject},
+			at:     hard.Add(time.Hour),
+		},
+		{
+			name:   "on time",
+			policy: Policy{SoftDeadline: soft, HardDeadline: hard, LatePolicy: LateLinear, Penalty: 10},
+			at:     soft.Add(-time.Hour),
+		},
+		{
+			name:   "at the soft deadline",
+			policy: Policy{SoftDeadline: soft, LatePolicy: LateReject},
+			at:     soft,
+		},
+		{
+			name:    "late, rejected",
+			policy:  Policy{SoftDeadline: soft, LatePolicy: LateReject},
+			at:      soft.Add(time.Minute),
+			wantErr: ErrPastDeadline,
+		},
+		{
+			name:        "late, linear",
+			policy:      Policy{SoftDeadline: soft, HardDeadline: hard, LatePolicy: LateLinear, Penalty: 10},
+			at:          soft.Add(25 * time.Hour),
+			wantLate:    25 * time.Hour,
+			wantPenalty: 20,
+		},
+		{
+			name:        "late, linear capped",
+			policy:      Policy{SoftDeadline: soft, LatePolicy: LateLinear, Penalty: 30},
+			at:          soft.Add(5 * 24 * time.Hour),
+			wantLate:    5 * 24 * time.Hour,
+			wantPenalty: 100,
+		},
+		{
+			name:        "late, step",
+			policy:      Policy{SoftDeadline: soft, LatePolicy: LateStep, Penalty: 25},
+			at:          soft.Add(3 * 24 * time.Hour),
+			wantLate:    3 * 24 * time.Hour,
+			wantPenalty: 25,
+		},
+		{
+			name:     "late, flagged",
+			policy:   Policy{SoftDeadline: soft, LatePolicy: LateFlag, Penalty: 50},
+			at:       soft.Add(time.Hour),
+			wantLate: time.Hour,
+		},
+		{
+			name:        "at the hard deadline",
+			policy:      Policy{SoftDeadline: soft, HardDeadline: hard, LatePolicy: LateStep, Penalty: 10},
+			at:          hard,
+			wantLate:    hard.Sub(soft),
+			wantPenalty: 10,
+		},
+		{
+			name:    "past the hard deadline",
+			policy:  Policy{SoftDeadline: soft, HardDeadline: hard, LatePolicy: LateFlag},
+			at:      hard.Add(time.Second),
+			wantErr: ErrPastDeadline,
+		},
+		{
+			name:    "past the hard deadline without a soft one",
+			policy:  Policy{HardDeadline: hard, LatePolicy: LateFlag},
+			at:      hard.Add(time.Second),
+			wantErr: ErrPastDeadline,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			late, penalty, err := tt.policy.Admit(tt.at)
+			if err != tt.wantErr {
+				t.Fatalf("Admit() error = %v, want %v", err, tt.wantErr)
+			}
+			if late != tt.wantLate || penalty != tt.wantPenalty {
+				t.Errorf("Admit() = %v, %d, want %v, %d", late, penalty, tt.wantLate, tt.wantPenalty)
+			}
+		})
+	}
+}
+
+func TestPolicyNextDeadline(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		at     time.Time
+		want   time.Time
+	}{
+		{name: "no deadlines", at: soft},
+		{name: "before soft", policy: Policy{SoftDeadline: soft, HardDeadline: hard}, at: soft.Add(-time.Hour), want: soft},
+		{name: "between", policy: Policy{SoftDeadline: soft, HardDeadline: hard}, at: soft.Add(time.Hour), want: hard},
+		{name: "soft only, passed", policy: Policy{SoftDeadline: soft}, at: soft.Add(time.Hour)},
+		{name: "hard only", policy: Policy{HardDeadline: hard}, at: soft, want: hard},
+		{name: "both passed", policy: Policy{SoftDeadline: soft, HardDeadline: hard}, at: hard.Add(time.Hour)},
+	}
+
+	for _, tt := range tests {
+		got := tt.policy.NextDeadline(tt.at)
+		if !got.Equal(tt.want) {
+			t.Errorf("%s: NextDeadline() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		ok     bool
+	}{
+		{name: "defaults", policy: Policy{}.WithDefaults(), ok: true},
+		{name: "no late policy", policy: Policy{}},
+		{name: "unknown late policy", policy: Policy{LatePolicy: "forgive"}},
+		{name: "penalty 100", policy: Policy{LatePolicy: LateStep, Penalty: 100}, ok: true},
+		{name: "penalty over 100", policy: Policy{LatePolicy: LateStep, Penalty: 101}},
+		{name: "negative penalty", policy: Policy{LatePolicy: LateLinear, Penalty: -1}},
+		{name: "hard after soft", policy: Policy{LatePolicy: LateFlag, SoftDeadline: soft, HardDeadline: hard}, ok: true},
+		{name: "hard before soft", policy: Policy{LatePolicy: LateFlag, SoftDeadline: hard, HardDeadline: soft}},
+	}
+
+	for _, tt := range tests {
+		err := tt.policy.Validate()
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestTaskVisible(t *testing.T) {
+	now := soft
+
+	tests := []struct {
+		name string
+		task Task
+		want bool
+	}{
+		{name: "draft", task: Task{State: StateDraft}},
+		{name: "published", task: Task{State: StatePublished}, want: true},
+		{name: "archived", task: Task{State: StateArchived}, want: true},
+		{name: "scheduled, not yet", task: Task{State: StateScheduled, PublishAt: now.Add(time.Minute)}},
+		{name: "scheduled, at publish time", task: Task{State: StateScheduled, PublishAt: now}, want: true},
+		{name: "scheduled, published", task: Task{State: StateScheduled, PublishAt: now.Add(-time.Minute)}, want: true},
+		{name: "deleted", task: Task{State: StatePublished, DeletedAt: now}},
+	}
+
+	for _, tt := range tests {
+		got := tt.task.Visible(now)
+		if got != tt.want {
+			t.Errorf("%s: Visible() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTaskAccepts(t *testing.T) {
+	now := soft
+
+	tests := []struct {
+		name  string
+		task  Task
+		staff bool
+		want  error
+	}{
+		{name: "published", task: Task{State: StatePublished}},
+		{name: "draft", task: Task{State: StateDraft}, want: ErrNotPublished},
+		{name: "draft, staff trying it", task: Task{State: StateDraft}, staff: true},
+		{name: "scheduled, not yet", task: Task{State: StateScheduled, PublishAt: now.Add(time.Hour)}, want: ErrNotPublished},
+		{name: "archived", task: Task{State: StateArchived}, want: ErrArchived},
+		{name: "archived, staff", task: Task{State: StateArchived}, staff: true, want: ErrArchived},
+		{name: "deleted", task: Task{State: StatePublished, DeletedAt: now}, staff: true, want: ErrNoTask},
+	}
+
+	for _, tt := range tests {
+		got := tt.task.Accepts(now, tt.staff)
+		if got != tt.want {
+			t.Errorf("%s: Accepts() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package delivery
 
 import (
+	"fmt"
 	"go.uber.org/zap"
+	"grader/pkg/server/authz"
+	"grader/pkg/server/page"
+	"grader/pkg/server/session"
 	"grader/pkg/server/user"
 	"grader/pkg/server/user/service"
 	"grader/pkg/utils"
@@ -16,6 +20,12 @@ type UserHandler struct {
 	UserService service.UserServiceInterface
 }
 
+type UsersData struct {
+	User  *user.Claims
+	Users []*user.User
+	Pager *page.Pager
+}
+
 func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 	err := h.Tmpl.ExecuteTemplate(w, "login.html", nil)
 	if err != nil {
@@ -32,24 +42,19 @@ func (h *UserHandler) SignUp(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-type Task struct {
-	TaskDescription string
-	TaskDeadline    string
-	TaskDetails     string
-}
-
+// Tasks sends the user to their task list, deadlines are shown there.
 func (h *UserHandler) Tasks(w http.ResponseWriter, r *http.Request) {
-	task := Task{
-		TaskDescription: "Sample Task",
-		TaskDeadline:    "2023-05-31",
-		TaskDetails:     "This is a sample task description.",
-	}
+	ctx := r.Context()
 
-	err := h.Tmpl.ExecuteTemplate(w, "index.html", task)
+	sess, err := session.SessionFromContext(ctx)
 	if err != nil {
-		http.Error(w, `Template error`, http.StatusInternalServerError)
+		utils.GetLogger(ctx).Error("error get session from context", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
+
+	url := fmt.Sprintf("/tasks/user/%s", sess.User.Username)
+	http.Redirect(w, r, url, http.StatusFound)
 }
 
 func (h *UserHandler) Auth(w http.ResponseWriter, r *http.Request) {
@@ -142,3 +147,117 @@ func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
 
 	http.Redirect(w, r, "/tasks", http.StatusFound)
 }
+
+// SetRole gives a user a global role, see authz.
+func (h *UserHandler) SetRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	err := h.UserService.SetRole(r.FormValue("username"), r.FormValue("role"))
+	if err == user.ErrBadRole || err == user.ErrNoUser {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error set user role", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/tasks/admin/users", http.StatusFound)
+}
+
+// userFilter reads a user list filter from the query: role and q, part of
+// the username.
+func userFilter(r *http.Request) (user.Filter, error) {
+	f := user.Filter{
+		Role:  r.FormValue("role"),
+		Query: r.FormValue("q"),
+	}
+
+	if f.Role != "" && !authz.ValidGlobalRole(f.Role) {
+		return user.Filter{}, page.ErrBadFilter
+	}
+
+	return f, nil
+}
+
+// Users shows a page of the users with their global roles.
+func (h *UserHandler) Users(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	data := &UsersData{}
+
+	sess, err := session.SessionFromContext(ctx)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get session from context", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	data.User = sess.User
+
+	f, err := userFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p, err := page.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	users, total, err := h.UserService.GetUsers(f, p)
+	if err == page.ErrBadPage {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get users", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	data.Users = users
+	data.Pager = page.NewPager(r, p, total)
+
+	err = h.Tmpl.ExecuteTemplate(w, "users.html", data)
+	if err != nil {
+		utils.GetLogger(ctx).Error("error execute template", zap.Error(err))
+		http.Error(w, `Template error`, http.StatusInternalServerError)
+		return
+	}
+}
+
+// UsersAPI answers a page of the users as JSON, filtered and sorted as
+// the users page is.
+func (h *UserHandler) UsersAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	f, err := userFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p, err := page.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	users, total, err := h.UserService.GetUsers(f, p)
+	if err == page.ErrBadPage {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.GetLogger(ctx).Error("error get users", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if users == nil {
+		users = []*user.User{}
+	}
+
+	utils.WriteJSONHandler(w, page.NewList(users, total, p), http.StatusOK)
+}
@@ -2,6 +2,7 @@ package repo
 
 import (
 	"database/sql"
+	"grader/pkg/server/page"
 	"grader/pkg/server/user"
 	"strconv"
 )
@@ -14,6 +15,8 @@ type UserRepoInterface interface {
 	Auth(string) (*user.User, error)
 	Create(string, string) (*user.User, error)
 	UserByID(string) (*user.User, error)
+	SetRole(string, string) error
+	List(user.Filter, page.Page) ([]*user.User, int, error)
 }
 
 func NewPgxRepo(db *sql.DB) *Pgx {
@@ -77,12 +80,12 @@ func (repo *Pgx) UserByID(userID string) (*user.User, error) {
 	}
 
 	row := repo.DB.QueryRow(`
-        SELECT id, username, password, admin
+        SELECT id, username, password, role
         FROM users
         WHERE id = $1
     `, id)
 
-	err = row.Scan(&id, &u.Username, &u.Password, &u.Admin)
+	err = row.Scan(&id, &u.Username, &u.Password, &u.Role)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, user.ErrNoUser
@@ -95,4 +98,72 @@ func (repo *Pgx) UserByID(userID string) (*user.User, error) {
 	return u, nil
 }
 
-//TODO added user list method and with query params limit offset
+func (repo *Pgx) SetRole(userID, role string) error {
+	_, err := repo.DB.Exec(`
+		UPDATE users
+		SET role = $1
+		WHERE id = $2
+	`, role, userID)
+
+	return err
+}
+
+// userSorts are the sort keys of user lists.
+var userSorts = map[string]string{
+	"id":       "id",
+	"username": "username",
+	"role":     "role",
+}
+
+// List returns the page of the users the filter lets through, without
+// their passwords, and how many it lets through in all.
+func (repo *Pgx) List(f user.Filter, p page.Page) ([]*user.User, int, error) {
+	where := &page.Where{}
+	if f.Role != "" {
+		where.Add("role = ?", f.Role)
+	}
+	if f.Query != "" {
+		where.Add("username ILIKE '%' || ?::text || '%'", f.Query)
+	}
+
+	order, err := p.SQL(userSorts, "id")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	err = repo.DB.QueryRow(`SELECT COUNT(*) FROM users `+where.String(), where.Args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := repo.DB.Query(`
+		SELECT id, username, role
+		FROM users
+		`+where.String()+`
+		`+order, where.Args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var users []*user.User
+	for rows.Next() {
+		var id int64
+		u := &user.User{}
+
+		err = rows.Scan(&id, &u.Username, &u.Role)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		u.ID = strconv.FormatInt(id, 10)
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
@@ -1,6 +1,8 @@
 package service
 
 import (
+	"grader/pkg/server/authz"
+	"grader/pkg/server/page"
 	"grader/pkg/server/session"
 	"grader/pkg/server/user"
 	"grader/pkg/server/user/repo"
@@ -12,6 +14,9 @@ type UserServiceInterface interface {
 	Logout(string) error
 	Register(username, password string) (string, error)
 	UserByID(string) (*user.User, error)
+	UserByName(string) (*user.User, error)
+	SetRole(string, string) error
+	GetUsers(user.Filter, page.Page) ([]*user.User, int, error)
 }
 
 type UserService struct {
@@ -79,3 +84,32 @@ func (h *UserService) UserByID(uID string) (*user.User, error) {
 
 	return u, nil
 }
+
+func (h *UserService) UserByName(username string) (*user.User, error) {
+	u, err := h.UserRepoPQ.Auth(username)
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// SetRole changes the global role of the user, see authz.
+func (h *UserService) SetRole(username, role string) error {
+	if !authz.ValidGlobalRole(role) {
+		return user.ErrBadRole
+	}
+
+	u, err := h.UserRepoPQ.Auth(username)
+	if err != nil {
+		return err
+	}
+
+	return h.UserRepoPQ.SetRole(u.ID, role)
+}
+
+// GetUsers returns the page of the users the filter lets through and how
+// many it lets through in all.
+func (h *UserService) GetUsers(f user.Filter, p page.Page) ([]*user.User, int, error) {
+	return h.UserRepoPQ.List(f, p)
+}
@@ -6,7 +6,15 @@ type User struct {
 	ID       string
 	Username string
 	Password string `json:"-"`
-	Admin    bool
+	// Role is the global role of the user, see authz.
+	Role string
+}
+
+// Filter narrows a user list, zero fields don't.
+type Filter struct {
+	Role string
+	// Query is part of the username, in any case.
+	Query string
 }
 
 type Claims struct {
@@ -18,4 +26,5 @@ var (
 	ErrNoUser             = errors.New("user not found")
 	ErrBadPassword        = errors.New("invalid password")
 	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrBadRole            = errors.New("bad role: global roles are student, teacher and admin")
 )